@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"github.com/rhzx3519/stream/types"
+	"math"
+)
+
+// Collector is a mutable reduction operation, like java.util.stream.Collector.
+// Supply builds the initial container, which parameter is the element size, or -1 if unknown size.
+// Accumulate adds a element into the container and returns it,
+// Finish converts the container to the final result.
+//
+// Collector 描述一个归约操作: Supply 创建初始容器, Accumulate 累计每个元素, Finish 转换为最终结果
+type Collector interface {
+	Supply(sizeMayNegative int64) types.R
+	Accumulate(acc types.R, t types.T) types.R
+	Finish(acc types.R) types.R
+}
+
+// region collector
+
+type collector struct {
+	supply     func(int64) types.R
+	accumulate func(acc types.R, t types.T) types.R
+	finish     types.Function
+}
+
+func (c *collector) Supply(size int64) types.R {
+	return c.supply(size)
+}
+
+func (c *collector) Accumulate(acc types.R, t types.T) types.R {
+	return c.accumulate(acc, t)
+}
+
+func (c *collector) Finish(acc types.R) types.R {
+	if c.finish == nil {
+		return acc
+	}
+	return c.finish(acc)
+}
+
+// end region collector
+
+// NewCollector creates a Collector by the given functions. `finish` may be nil which means return the container as is
+func NewCollector(supply func(sizeMayNegative int64) types.R, accumulate func(acc types.R, t types.T) types.R, finish types.Function) Collector {
+	return &collector{
+		supply:     supply,
+		accumulate: accumulate,
+		finish:     finish,
+	}
+}
+
+// ToSliceCollector collects elements into a []types.T
+func ToSliceCollector() Collector {
+	return NewCollector(func(size int64) types.R {
+		return make([]types.T, 0, capacityOf(size))
+	}, func(acc types.R, t types.T) types.R {
+		return append(acc.([]types.T), t)
+	}, nil)
+}
+
+// CountingCollector counts elements, the result type is int64
+func CountingCollector() Collector {
+	return NewCollector(func(int64) types.R {
+		return int64(0)
+	}, func(acc types.R, t types.T) types.R {
+		return acc.(int64) + 1
+	}, nil)
+}
+
+// ReducingCollector reduces elements from `identity` using `accumulator`, same as Stream.ReduceFrom
+func ReducingCollector(identity types.T, accumulator types.BinaryOperator) Collector {
+	return NewCollector(func(int64) types.R {
+		return identity
+	}, func(acc types.R, t types.T) types.R {
+		return accumulator(acc, t)
+	}, nil)
+}
+
+// TeeingCollector feeds each element to both collectors, and merges the two results at finish.
+// it's a Collector itself, so it can be nested or used as the downstream of GroupByThen
+func TeeingCollector(c1, c2 Collector, merge func(r1, r2 types.R) types.R) Collector {
+	return NewCollector(func(size int64) types.R {
+		return types.Pair{
+			First:  c1.Supply(size),
+			Second: c2.Supply(size),
+		}
+	}, func(acc types.R, t types.T) types.R {
+		pair := acc.(types.Pair)
+		pair.First = c1.Accumulate(pair.First, t)
+		pair.Second = c2.Accumulate(pair.Second, t)
+		return pair
+	}, func(acc types.T) types.R {
+		pair := acc.(types.Pair)
+		return merge(c1.Finish(pair.First), c2.Finish(pair.Second))
+	})
+}
+
+// SummaryStatistics holds count, sum, min, max of numbers.
+// like Java's DoubleSummaryStatistics, Min is +Inf and Max is -Inf if Count is 0
+type SummaryStatistics struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// NewSummaryStatistics creates a empty SummaryStatistics
+func NewSummaryStatistics() *SummaryStatistics {
+	return &SummaryStatistics{
+		Min: math.Inf(1),
+		Max: math.Inf(-1),
+	}
+}
+
+// Accept records a number
+func (s *SummaryStatistics) Accept(value float64) {
+	s.Count++
+	s.Sum += value
+	s.Min = math.Min(s.Min, value)
+	s.Max = math.Max(s.Max, value)
+}
+
+// Average returns the arithmetic mean, or 0 if Count is 0
+func (s *SummaryStatistics) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// SummaryStatisticsCollector collects the numbers `toFloat` returns into a *SummaryStatistics
+func SummaryStatisticsCollector(toFloat func(t types.T) float64) Collector {
+	return NewCollector(func(int64) types.R {
+		return NewSummaryStatistics()
+	}, func(acc types.R, t types.T) types.R {
+		acc.(*SummaryStatistics).Accept(toFloat(t))
+		return acc
+	}, nil)
+}
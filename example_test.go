@@ -1,12 +1,21 @@
 package stream_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/rhzx3519/stream"
+	"github.com/rhzx3519/stream/optional"
 	"github.com/rhzx3519/stream/types"
+	"math"
 	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 
@@ -55,6 +64,20 @@ func ExampleOf() {
 	// 1,2,3,4,
 }
 
+func ExampleJust() {
+	// 按条件发送一个或零个元素
+	evens := stream.IntRange(0, 6).FlatMap(func(t types.T) stream.Stream {
+		if t.(int)%2 == 0 {
+			return stream.Just(t)
+		}
+		return stream.Empty()
+	})
+	fmt.Println(evens.ToSlice())
+	fmt.Println(stream.Just(nil).Count(), stream.Empty().Count())
+	// Output:
+	// [0 2 4]
+	// 1 0
+}
 func ExampleOfInts() {
 	var ints = []int{1, 2, 3, 4}
 	stream.OfInts(ints...).ForEach(func(e types.T) {
@@ -117,6 +140,22 @@ func ExampleOfSlice() {
 	// 1,2,3,4,<a>,<b>,
 }
 
+func ExampleFromSlice() {
+	type point struct {
+		X, Y int
+	}
+	fmt.Println(stream.FromSlice([]point{{1, 2}, {3, 4}}).Count())
+	fmt.Println(stream.FromSlice([3]string{"a", "b", "c"}).ToSlice())
+	defer func() {
+		fmt.Println(recover())
+	}()
+	stream.FromSlice(1)
+	// Output:
+	// 2
+	// [a b c]
+	// not slice
+}
+
 //func ExampleOfMap() {
 //	var m1 = map[int]string{
 //		3: "c",
@@ -143,6 +182,54 @@ func ExampleOfSlice() {
 //	// [{a 1} {b 2} {c 3}]
 //}
 
+func ExampleFromSyncMap() {
+	var m sync.Map
+	m.Store(1, "a")
+	m.Store(2, "b")
+	s := stream.FromSyncMap(&m).Sorted(func(left, right types.T) int {
+		return left.(types.Pair).First.(int) - right.(types.Pair).First.(int)
+	})
+	m.Store(3, "c") // stored before traversal, so it is included
+	fmt.Println(s.ToSlice())
+	// Output:
+	// [{1 a} {2 b} {3 c}]
+}
+
+func ExampleFromLines() {
+	r := strings.NewReader("a\nbb\nccc\ndddd\n")
+	stream.FromLines(r).
+		Limit(3).
+		Map(func(t types.T) types.R {
+			return len(t.(string))
+		}).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	// Output:
+	// 1,2,3,
+}
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func ExampleFromLines_error() {
+	defer func() {
+		fmt.Println(recover())
+	}()
+	stream.FromLines(errReader{errors.New("broken")}).
+		OnClose(func() {
+			fmt.Println("close")
+		}).
+		Count()
+	// Output:
+	// close
+	// broken
+}
+
 func ExampleIterate() {
 	// 0   1   1   2 3 5 8
 	// |   |  next
@@ -162,6 +249,15 @@ func ExampleIterate() {
 	// Output:
 	// 1,1,2,3,5,8,
 }
+func ExampleIterateN() {
+	stream.IterateN(1, func(t types.T) types.T {
+		return t.(int) * 3
+	}, 5).ForEach(func(t types.T) {
+		fmt.Printf("%d,", t)
+	})
+	// Output:
+	// 1,3,9,27,81,
+}
 func ExampleGenerate() {
 	// fibonacci 斐波那契数列 0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89, 144, 233，377，610，987，1597，2584，4181，6765，10946，17711，28657，46368
 	var fibonacci = func() types.Supplier {
@@ -181,6 +277,35 @@ func ExampleGenerate() {
 	// Output:
 	// 0,1,1,2,3,5,8,13,21,34,55,89,144,233,377,610,987,1597,2584,4181,
 }
+func ExampleGenerateN() {
+	i := 0
+	ints := stream.GenerateN(func() types.R {
+		i++
+		return i
+	}, 4).ReduceBy(func(sizeMayNegative int64) types.R {
+		fmt.Printf("size=%d\n", sizeMayNegative)
+		return make([]int, 0, sizeMayNegative)
+	}, func(acc types.R, e types.T) types.R {
+		return append(acc.([]int), e.(int))
+	})
+	fmt.Println(ints)
+	// Output:
+	// size=4
+	// [1 2 3 4]
+}
+func ExampleGenerate_memoize() {
+	calls := 0
+	stream.Generate(types.Memoize(func() types.R {
+		calls++
+		return "expensive"
+	})).Limit(3).ForEach(func(t types.T) {
+		fmt.Printf("%s,", t)
+	})
+	fmt.Printf("\ncalls=%d\n", calls)
+	// Output:
+	// expensive,expensive,expensive,
+	// calls=1
+}
 func ExampleRepeat() {
 	count := stream.Repeat("a").Peek(func(t types.T) {
 		fmt.Printf("%s", t)
@@ -197,6 +322,112 @@ func ExampleRepeatN() {
 	// Output:
 	// <float64,1><float64,1><float64,1>
 }
+func ExampleGenerator() {
+	type node struct {
+		value       int
+		left, right *node
+	}
+	tree := &node{4, &node{2, &node{1, nil, nil}, &node{3, nil, nil}}, &node{6, &node{5, nil, nil}, nil}}
+	var walk func(n *node, emit types.Consumer)
+	walk = func(n *node, emit types.Consumer) {
+		if n == nil {
+			return
+		}
+		walk(n.left, emit)
+		emit(n.value)
+		walk(n.right, emit)
+	}
+	inOrder := func(emit types.Consumer) {
+		walk(tree, emit)
+	}
+	fmt.Println(stream.Generator(inOrder).ToSlice())
+	// 提前结束时 goroutine 也会退出
+	fmt.Println(stream.Generator(inOrder).Limit(2).ToSlice())
+
+	defer func() {
+		fmt.Println("recovered:", recover())
+	}()
+	stream.Generator(func(emit types.Consumer) {
+		emit(1)
+		panic("producer failed")
+	}).ForEach(func(t types.T) {
+		fmt.Println(t)
+	})
+	// Output:
+	// [1 2 3 4 5 6]
+	// [1 2]
+	// 1
+	// recovered: producer failed
+}
+
+func ExampleConcatAll() {
+	shards := []stream.Stream{
+		stream.OfInts(1, 2),
+		stream.OfInts(3).OnClose(func() {
+			fmt.Println("shard 1 closed")
+		}),
+		stream.OfInts(4, 5).Peek(func(t types.T) {
+			fmt.Println("visit", t)
+		}),
+	}
+	fmt.Println(stream.ConcatAll(shards...).Limit(3).ToSlice())
+	fmt.Println(stream.ConcatAll(stream.OfInts(1, 2), stream.IntRange(0, 3), stream.Of()).ToSlice())
+	// 所有的数据源大小都已知时, Count 不需要遍历
+	fmt.Println(stream.ConcatAll(stream.RepeatN(0, 1000), stream.OfInts(1, 2).OnClose(func() {})).Count())
+	// Output:
+	// shard 1 closed
+	// [1 2 3]
+	// [1 2 0 1 2]
+	// 1002
+}
+
+func ExampleMergeByKey() {
+	key := func(t types.T) types.R {
+		return t.(types.Pair).First
+	}
+	shards := []stream.Stream{
+		stream.Of(types.Pair{First: 1, Second: "a"}, types.Pair{First: 4, Second: "a"}),
+		stream.Of(types.Pair{First: 2, Second: "b"}, types.Pair{First: 4, Second: "b"}),
+		stream.Of(),
+		stream.Of(types.Pair{First: 3, Second: "d"}).OnClose(func() {
+			fmt.Println("shard 3 closed")
+		}),
+	}
+	fmt.Println(stream.MergeByKey(shards, key, types.IntComparator).ToSlice())
+
+	// 每个流每次只拉取一个元素, 所以可以归并无限流
+	self := func(t types.T) types.R {
+		return t
+	}
+	odd := stream.Iterate(1, func(t types.T) types.T {
+		return t.(int) + 2
+	})
+	even := stream.Iterate(0, func(t types.T) types.T {
+		return t.(int) + 2
+	}).Limit(3)
+	fmt.Println(stream.MergeByKey([]stream.Stream{odd, even}, self, types.IntComparator).Limit(8).ToSlice())
+	fmt.Println(stream.MergeByKey([]stream.Stream{stream.OfInts(1, 3), stream.RepeatN(2, 3)}, self, types.IntComparator).Count())
+	// Output:
+	// shard 3 closed
+	// [{1 a} {2 b} {3 d} {4 a} {4 b}]
+	// [0 1 2 3 4 5 7 9]
+	// 5
+}
+
+func ExampleCycle() {
+	fixture := []types.T{"a", "b", "c"}
+	fmt.Println(stream.Cycle(fixture, 2).ToSlice())
+	fmt.Println(stream.Cycle(fixture, 2).Count())
+	fmt.Println(stream.Cycle(fixture, -1).Limit(7).ToSlice())
+	fmt.Println(stream.Cycle(nil, -1).Count())
+	fmt.Println(stream.Cycle(fixture, 0).Count())
+	// Output:
+	// [a b c a b c]
+	// 6
+	// [a b c a b c a]
+	// 0
+	// 0
+}
 func ExampleIntRange() {
 	stream.IntRange(0, 5).
 		ForEach(func(t types.T) {
@@ -250,6 +481,78 @@ func ExampleInt64RangeStep() {
 	// 0369
 }
 
+func ExampleRangeRune() {
+	stream.RangeRune('a', 'f').ForEach(func(t types.T) {
+		fmt.Printf("%c(%T),", t, t)
+	})
+	fmt.Println()
+	fmt.Println(stream.RangeRune('a', 'a').Count())
+	// Output:
+	// a(int32),b(int32),c(int32),d(int32),e(int32),
+	// 0
+}
+
+func ExampleEqual() {
+	eq := func(x, y types.T) bool {
+		return x == y
+	}
+	fmt.Println(stream.Equal(stream.IntRange(0, 5), stream.Of(4, 3, 2, 1, 0).Sorted(types.IntComparator), eq))
+	fmt.Println(stream.Equal(stream.IntRange(0, 5), stream.IntRange(0, 4), eq))
+	fmt.Println(stream.Equal(stream.Of(), stream.Of(), eq))
+	// 遇到第一个不相等的元素就结束, 所以无限流也可以比较
+	pulled := 0
+	equal := stream.Equal(stream.Iterate(0, func(t types.T) types.T {
+		return t.(int) + 1
+	}).Peek(func(types.T) {
+		pulled++
+	}).OnClose(func() {
+		fmt.Println("closed")
+	}), stream.Of(0, 1, 5), eq)
+	fmt.Println(equal, pulled)
+	// Output:
+	// true
+	// false
+	// true
+	// closed
+	// false 3
+}
+
+func ExampleGenerate_infinite() {
+	try := func(f func() types.R) {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+		fmt.Println(f())
+	}
+	try(func() types.R {
+		return stream.Repeat(1).Count()
+	})
+	try(func() types.R {
+		return stream.Repeat(1).Sorted(types.IntComparator).Limit(3).Count()
+	})
+	try(func() types.R {
+		return stream.Repeat(1).Sorted(types.IntComparator).FindFirst()
+	})
+	try(func() types.R {
+		return stream.IntRangeStep(0, 5, 0).Count()
+	})
+	try(func() types.R {
+		return stream.Repeat(1).Limit(3).Sorted(types.IntComparator).Count()
+	})
+	try(func() types.R {
+		return stream.Repeat(1).FindFirst().Get()
+	})
+	// Output:
+	// infinite stream requires a Limit before a buffering terminal
+	// infinite stream requires a Limit before a buffering terminal
+	// infinite stream requires a Limit before a buffering terminal
+	// infinite stream requires a Limit before a buffering terminal
+	// 3
+	// 1
+}
+
 func ExampleStream_Filter() {
 	stream.Of(0, 1, 2, 3, 4, 5, 6, 7, 8, 9).
 		Filter(func(e types.T) bool {
@@ -264,6 +567,32 @@ func ExampleStream_Filter() {
 	// 6
 	// 9
 }
+func ExampleStream_FilterCounting() {
+	var invalid int64
+	valid := stream.OfStrings("1", "x", "3", "", "5").
+		FilterCounting(func(t types.T) bool {
+			_, err := strconv.Atoi(t.(string))
+			return err == nil
+		}, &invalid).
+		ToSlice()
+	fmt.Println(valid, invalid)
+	// Output:
+	// [1 3 5] 2
+}
+func ExampleStream_FilterIndexed() {
+	stream.IntRange(0, 10).
+		Filter(func(t types.T) bool {
+			return t.(int)%3 != 0
+		}).
+		FilterIndexed(func(index int64, t types.T) bool {
+			return index%2 == 0
+		}).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	// Output:
+	// 1,4,7,
+}
 func ExampleStream_Map() {
 	stream.IntRange(0, 5).
 		Map(func(t types.T) types.R {
@@ -275,6 +604,149 @@ func ExampleStream_Map() {
 	// Output:
 	// <0><1><2><3><4>
 }
+func ExampleStream_Cast() {
+	fmt.Println(stream.FromSlice([]interface{}{1, 2, 3}).
+		Cast(reflect.TypeOf(0)).
+		Map(func(t types.T) types.R {
+			return t.(int) * 2
+		}).
+		ToSlice())
+
+	defer func() {
+		err := recover().(error)
+		fmt.Println(errors.Is(err, stream.ErrNotAssignable), err)
+	}()
+	stream.Of(1, "2", 3).Cast(reflect.TypeOf(0)).Count()
+	// Output:
+	// [2 4 6]
+	// true not assignable: string to int
+}
+
+func ExampleStream_Deref() {
+	type user struct {
+		Name string
+	}
+	users := []*user{{"alice"}, nil, {"bob"}}
+	fmt.Println(stream.FromSlice(users).DerefOr(user{"guest"}).ToSlice())
+
+	defer func() {
+		err := recover().(error)
+		fmt.Println(errors.Is(err, stream.ErrNilPointer), err)
+	}()
+	stream.FromSlice(users).Deref().ForEach(func(t types.T) {
+		fmt.Println(t.(user).Name)
+	})
+	// Output:
+	// [{alice} {guest} {bob}]
+	// alice
+	// true nil pointer: *stream_test.user
+}
+
+func ExampleStream_MapOptional() {
+	tryParseInt := func(t types.T) optional.Optional {
+		i, err := strconv.Atoi(t.(string))
+		if err != nil {
+			return optional.Empty()
+		}
+		return optional.Of(i)
+	}
+	fmt.Println(stream.OfStrings("1", "a", "2", "", "3").MapOptional(tryParseInt).ToSlice())
+	// Output:
+	// [1 2 3]
+}
+func ExampleStream_MapWith() {
+	multiply := func(t types.T, u types.U) types.R {
+		return t.(float64) * u.(float64)
+	}
+	scale := 2.5
+	fmt.Println(stream.OfFloat64s(1, 2, 4).MapWith(scale, multiply).ToSlice())
+	// Output:
+	// [2.5 5 10]
+}
+
+func ExampleStream_MapAll() {
+	var steps []types.Function
+	for _, step := range []string{"trim", "upper", "quote"} {
+		switch step {
+		case "trim":
+			steps = append(steps, func(t types.T) types.R {
+				return strings.TrimSpace(t.(string))
+			})
+		case "upper":
+			steps = append(steps, func(t types.T) types.R {
+				return strings.ToUpper(t.(string))
+			})
+		case "quote":
+			steps = append(steps, func(t types.T) types.R {
+				return strconv.Quote(t.(string))
+			})
+		}
+	}
+	s := stream.OfStrings(" go ", "java").MapAll(steps...)
+	fmt.Println(s.Explain())
+	fmt.Println(s.ToSlice())
+	// Output:
+	// MapAll(3)
+	// ["GO" "JAVA"]
+}
+
+func ExampleStream_MapInPlace() {
+	type point struct {
+		X, Y float64
+	}
+	points := []*point{{1, 2}, {3, 4}}
+	stream.OfSlice(points).
+		MapInPlace(func(t types.T) types.T {
+			p := t.(*point)
+			p.X, p.Y = p.X*10, p.Y*10
+			return p
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(*t.(*point))
+		})
+	fmt.Println(*points[0]) // 原来的元素也被修改了
+	// Output:
+	// {10 20}
+	// {30 40}
+	// {10 20}
+}
+
+func ExampleStream_TryMap() {
+	mustAtoi := func(t types.T) types.R {
+		i, err := strconv.Atoi(t.(string))
+		if err != nil {
+			panic(err)
+		}
+		return i
+	}
+	stream.OfStrings("1", "a", "3").TryMap(mustAtoi).ForEach(func(t types.T) {
+		fmt.Println(t)
+	})
+	fmt.Println(stream.OfStrings("1", "a", "2", "", "3").
+		TryMap(mustAtoi).
+		MapOptional(func(t types.T) optional.Optional {
+			return t.(optional.Optional)
+		}).
+		ToSlice())
+	// Output:
+	// Optional[1]
+	// Optional.empty
+	// Optional[3]
+	// [1 2 3]
+}
+func ExampleStream_MapConcurrent() {
+	stream.IntRange(0, 10).
+		MapConcurrent(4, func(t types.T) types.R {
+			time.Sleep(time.Duration(10-t.(int)) * time.Millisecond) // later elements finish earlier
+			return t.(int) * t.(int)
+		}).
+		Limit(8).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	// Output:
+	// 0,1,4,9,16,25,36,49,
+}
 func ExampleStream_FlatMap() {
 	stream.Of([]int{0, 2, 4, 6, 8}, []int{1, 3, 5, 7, 9}).
 		FlatMap(func(t types.T) stream.Stream {
@@ -286,6 +758,58 @@ func ExampleStream_FlatMap() {
 	// Output:
 	// 0246813579
 }
+func ExampleStream_FlatMap_shortCircuit() {
+	visited := 0
+	slice := stream.OfInts(1, 2).
+		FlatMap(func(t types.T) stream.Stream {
+			return stream.IntRange(0, 1000).Peek(func(types.T) {
+				visited++
+			})
+		}).
+		Limit(3).
+		ToSlice()
+	fmt.Println(slice, visited)
+	// 子流是无限流也可以
+	fmt.Println(stream.OfStrings("a", "b").
+		FlatMap(func(t types.T) stream.Stream {
+			return stream.Repeat(t)
+		}).
+		Limit(3).
+		ToSlice())
+	// Output:
+	// [0 1 2] 3
+	// [a a a]
+}
+func ExampleStream_FlatMapSized() {
+	slices := [][]int{{1, 2}, {3, 4}, {5, 6}}
+	result := stream.OfSlice(slices).
+		FlatMapSized(6, func(t types.T) stream.Stream {
+			return stream.OfSlice(t)
+		}).
+		ReduceBy(func(sizeMayNegative int64) types.R {
+			fmt.Printf("size=%d\n", sizeMayNegative)
+			return make([]int, 0, sizeMayNegative)
+		}, func(acc types.R, e types.T) types.R {
+			return append(acc.([]int), e.(int))
+		})
+	fmt.Println(result)
+	// Output:
+	// size=6
+	// [1 2 3 4 5 6]
+}
+func ExampleStream_Flatten() {
+	stream.Of([]types.T{1, 2}, stream.Of(3, 4), []types.T{}, stream.IntRange(5, 7)).
+		Flatten().
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	defer func() {
+		fmt.Println(recover())
+	}()
+	stream.Of([]int{1}).Flatten().Count()
+	// Output:
+	// 1,2,3,4,5,6,not flattenable
+}
 func ExampleStream_Peek() {
 	stream.Of(1, 2, 3, 4, 5).Peek(func(t types.T) {
 		fmt.Printf("%d,", t)
@@ -304,90 +828,929 @@ func ExampleStream_Peek_peekIsNotTerminalAction() {
 	// 1,2,3,4,5,
 }
 
-func ExampleStream_Distinct() {
-	fmt.Println(stream.RepeatN(1, 10).Distinct(func(t types.T) int {
-		return t.(int)
-	}).Count())
+func ExampleStream_OnClose() {
+	stream.Of(1, 2, 3).
+		OnClose(func() {
+			fmt.Println("close 1")
+		}).
+		Limit(1).
+		OnClose(func() {
+			fmt.Println("close 2")
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
 	// Output:
 	// 1
+	// close 1
+	// close 2
 }
-func ExampleStream_Sorted() {
-	stream.IntRange(1, 10).
-		Sorted(types.ReverseOrder(types.IntComparator)).
-		ForEach(func(t types.T) {
-			fmt.Printf("%d,", t)
-		})
+
+func ExampleStream_PeekLog() {
+	logf := func(format string, args ...interface{}) { // 测试中可以直接使用 t.Logf
+		fmt.Printf(format+"\n", args...)
+	}
+	stream.IntRange(0, 6).
+		PeekLog(logf, "source: ").
+		Filter(func(t types.T) bool {
+			return t.(int)%2 == 0
+		}).
+		PeekLog(logf, "filtered: ").
+		PeekLog(nil, "ignored: ").
+		Count()
 	// Output:
-	// 9,8,7,6,5,4,3,2,1,
+	// source: 0
+	// filtered: 0
+	// source: 1
+	// source: 2
+	// filtered: 2
+	// source: 3
+	// source: 4
+	// filtered: 4
+	// source: 5
 }
-func ExampleStream_Sorted_int64() {
-	stream.Int64RangeStep(100, 0, -10).
-		Peek(func(t types.T) {
-			fmt.Printf("%d,", t)
+
+func ExampleStream_Collecting() {
+	var survived []types.T
+	sum := stream.IntRange(0, 10).
+		Filter(func(t types.T) bool {
+			return t.(int)%3 == 0
 		}).
-		Sorted(types.Int64Comparator).
-		ForEach(func(t types.T) {
-			fmt.Printf("%d,", t)
+		Collecting(&survived).
+		ReduceFrom(0, func(acc types.T, t types.T) types.T {
+			return acc.(int) + t.(int)
 		})
+	fmt.Println(survived, sum)
 	// Output:
-	// 100,90,80,70,60,50,40,30,20,10,10,20,30,40,50,60,70,80,90,100,
+	// [0 3 6 9] 18
 }
-func ExampleStream_Limit() {
-	fmt.Println(stream.Repeat(nil).Limit(100).Count())
+func ExampleStream_WithProgress() {
+	report := func(done int64, total int64) {
+		fmt.Printf("%d/%d\n", done, total)
+	}
+	stream.OfSlice(make([]int, 10)).WithProgress(4, report).Count()
+	stream.Repeat(0).Limit(5).WithProgress(2, report).Count()
 	// Output:
-	// 100
+	// 4/10
+	// 8/10
+	// 2/-1
+	// 4/-1
 }
-func ExampleStream_Skip() {
-	stream.IntRange(0, 10).Skip(5).ForEach(func(t types.T) {
-		fmt.Printf("%d,", t)
-	})
+func ExampleStream_PeekProgress() {
+	logf := func(index, total, done int64, t types.T) {
+		fmt.Printf("[%d/%d] #%d %v\n", done, total, index, t)
+	}
+	stream.OfStrings("a", "b", "c").PeekProgress(logf).Count()
+	stream.OfStrings("a", "", "c").
+		Filter(func(t types.T) bool {
+			return t != ""
+		}).
+		PeekProgress(logf).
+		Count()
 	// Output:
-	// 5,6,7,8,9,
+	// [1/3] #0 a
+	// [2/3] #1 b
+	// [3/3] #2 c
+	// [1/-1] #0 a
+	// [2/-1] #1 c
 }
-
-func ExampleStream_ForEach() {
-	stream.Of("hello", "world").ForEach(func(t types.T) {
-		fmt.Println(t)
-	})
+func ExampleStream_PeekLifecycle() {
+	stream.OfInts(3, 1, 2).
+		Sorted(types.IntComparator).
+		PeekLifecycle(func(size int64) {
+			fmt.Printf("begin size=%d\n", size)
+		}, func(t types.T) {
+			fmt.Printf("each %d\n", t)
+		}, func() {
+			fmt.Println("end")
+		}).
+		Count()
 	// Output:
-	// hello
-	// world
+	// begin size=3
+	// each 1
+	// each 2
+	// each 3
+	// end
 }
-func ExampleStream_ToSlice() {
-	slice := stream.Of(1, 2, 3).ToSlice()
-	fmt.Printf("%#v\n", slice)
+func ExampleStream_OnStart() {
+	var start time.Time
+	var elapsed time.Duration
+	n := stream.IntRange(0, 100).
+		Filter(func(t types.T) bool {
+			return t.(int)%3 == 0
+		}).
+		OnStart(func(sizeHint int64) {
+			start = time.Now()
+			fmt.Println("start, size hint:", sizeHint)
+		}).
+		Map(func(t types.T) types.R {
+			return t.(int) * 2
+		}).
+		OnComplete(func() {
+			elapsed = time.Since(start)
+			fmt.Println("complete")
+		}).
+		Count()
+	fmt.Println(n, elapsed >= 0)
 	// Output:
-	// []types.T{1, 2, 3}
+	// start, size hint: -1
+	// complete
+	// 34 true
 }
-func ExampleStream_ToElementSlice() {
-	slice := stream.Of(1, 2, 3).ToElementSlice(0)
-	fmt.Printf("%#v\n", slice)
+func ExampleStream_MapToPair() {
+	stream.OfStrings("go", "java").
+		MapToPair(func(t types.T) types.R {
+			return t
+		}, func(t types.T) types.R {
+			return len(t.(string))
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
 	// Output:
-	// []int{1, 2, 3}
+	// {go 2}
+	// {java 4}
 }
-func ExampleStream_ToSliceOf() {
-	slice := stream.Of(1, 2, 3).ToSliceOf(reflect.TypeOf(0))
-	fmt.Printf("%#v\n", slice)
+func ExampleStream_Enumerate() {
+	stream.OfStrings("a", "", "b", "c").
+		Filter(func(t types.T) bool {
+			return t != ""
+		}).
+		Enumerate().
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	fmt.Println(stream.OfStrings("x", "y").Enumerate().Keys().ToSlice())
 	// Output:
-	// []int{1, 2, 3}
+	// {0 a}
+	// {1 b}
+	// {2 c}
+	// [0 1]
 }
-func ExampleStream_AllMatch() {
-	allMatch := stream.IntRange(0, 10).AllMatch(func(t types.T) bool {
-		i, ok := t.(int)
-		return ok && i >= 0 && i < 10
-	})
-	fmt.Println(allMatch)
-	allMatch = stream.IntRange(0, 10).AllMatch(func(t types.T) bool {
-		i, ok := t.(int)
-		return ok && i > 0 && i < 10
-	})
-	fmt.Println(allMatch)
-	noElementSoResultShouldBeFalse := stream.Of().AnyMatch(func(t types.T) bool {
-		return true
-	})
-	fmt.Println(noElementSoResultShouldBeFalse)
+func ExampleStream_MapKeys() {
+	stream.OfMap(map[string]int{"a": 1}).
+		MapKeys(func(t types.T) types.R {
+			return strings.ToUpper(t.(string))
+		}).
+		MapValues(func(t types.T) types.R {
+			return t.(int) * 10
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
 	// Output:
-	// true
+	// {A 10}
+}
+func ExampleStream_MapPair() {
+	entries := []types.Pair{{First: "Name", Second: " Alice "}, {First: "CITY", Second: "Paris "}}
+	lower := func(t types.T) types.R {
+		return strings.ToLower(t.(string))
+	}
+	trim := func(t types.T) types.R {
+		return strings.TrimSpace(t.(string))
+	}
+	fmt.Printf("%q\n", stream.OfSlice(entries).MapPair(lower, trim).ToSlice())
+	fmt.Printf("%q\n", stream.OfSlice(entries).MapPair(lower, nil).ToSlice())
+	// Output:
+	// [{"name" "Alice"} {"city" "Paris"}]
+	// [{"name" " Alice "} {"city" "Paris "}]
+}
+func ExampleStream_Keys() {
+	entries := []types.Pair{{First: "a", Second: 1}, {First: "b", Second: 2}}
+	fmt.Println(stream.OfSlice(entries).Keys().ToSlice())
+	fmt.Println(stream.OfSlice(entries).Values().ToSlice())
+	// Output:
+	// [a b]
+	// [1 2]
+}
+func ExampleStream_Map_swapPair() {
+	stream.Of(types.NewPair("a", 1), types.NewPair("b", 2)).
+		Map(func(t types.T) types.R {
+			return t.(types.Pair).Swap()
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	// Output:
+	// {1 a}
+	// {2 b}
+}
+func ExampleStream_Recover() {
+	stream.Of("1", "x", "3", "y").
+		Recover(func(recovered interface{}, element types.T) types.T {
+			fmt.Printf("bad element %q: %v\n", element, recovered)
+			if element == "y" {
+				return nil // skip
+			}
+			return -1
+		}).
+		Map(func(t types.T) types.R {
+			if s, ok := t.(string); ok {
+				i, err := strconv.Atoi(s)
+				if err != nil {
+					panic("not a number")
+				}
+				return i
+			}
+			return t
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	// Output:
+	// 1
+	// bad element "x": not a number
+	// -1
+	// 3
+	// bad element "y": not a number
+}
+
+func ExampleStream_Distinct() {
+	fmt.Println(stream.RepeatN(1, 10).Distinct(func(t types.T) int {
+		return t.(int)
+	}).Count())
+	// Output:
+	// 1
+}
+func ExampleStream_Duplicates() {
+	id := func(t types.T) int {
+		return t.(int)
+	}
+	fmt.Println(stream.OfInts(1, 2, 1, 3, 1, 2).Duplicates(id, false).ToSlice())
+	fmt.Println(stream.OfInts(1, 2, 1, 3, 1, 2).Duplicates(id, true).ToSlice())
+	// Output:
+	// [1 2]
+	// [1 1 2]
+}
+func ExampleStream_DistinctLast() {
+	type event struct {
+		ID      int
+		Version int
+	}
+	stream.Of(event{1, 1}, event{2, 1}, event{1, 2}, event{3, 1}, event{2, 2}).
+		DistinctLast(func(t types.T) int {
+			return t.(event).ID
+		}).
+		ForEach(func(t types.T) {
+			fmt.Printf("%v,", t)
+		})
+	// Output:
+	// {1 2},{3 1},{2 2},
+}
+func ExampleStream_DistinctByHashEquals() {
+	stream.OfStrings("ab", "cd", "ab", "ef", "cd").
+		DistinctByHashEquals(func(t types.T) int {
+			return len(t.(string)) // every element collides
+		}, func(a, b types.T) bool {
+			return a == b
+		}).
+		ForEach(func(t types.T) {
+			fmt.Printf("%s,", t)
+		})
+	// Output:
+	// ab,cd,ef,
+}
+func ExampleStream_DistinctBounded() {
+	identity := func(t types.T) int {
+		return t.(int)
+	}
+	fmt.Println(stream.OfInts(1, 2, 3, 1, 3, 2).DistinctBounded(identity, 2, stream.DistinctPassThrough).ToSlice())
+	fmt.Println(stream.OfInts(1, 2, 3, 1, 3, 2).DistinctBounded(identity, 2, stream.DistinctEvictLRU).ToSlice())
+	fmt.Println(stream.OfInts(1, 1, 1).DistinctBounded(identity, 0, stream.DistinctEvictLRU).ToSlice())
+	// Output:
+	// [1 2 3 3]
+	// [1 2 3 1 2]
+	// [1 1 1]
+}
+func ExampleStream_Sorted() {
+	stream.IntRange(1, 10).
+		Sorted(types.ReverseOrder(types.IntComparator)).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	// Output:
+	// 9,8,7,6,5,4,3,2,1,
+}
+func ExampleStream_Sorted_int64() {
+	stream.Int64RangeStep(100, 0, -10).
+		Peek(func(t types.T) {
+			fmt.Printf("%d,", t)
+		}).
+		Sorted(types.Int64Comparator).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	// Output:
+	// 100,90,80,70,60,50,40,30,20,10,10,20,30,40,50,60,70,80,90,100,
+}
+func ExampleStream_SortedInto() {
+	scratch := make([]types.T, 0, 8)
+	for _, data := range [][]int{{3, 1, 2}, {9, 7, 8, 6}} {
+		fmt.Println(stream.OfInts(data...).SortedInto(types.IntComparator, scratch).ToSlice())
+	}
+	fmt.Println(scratch[:4]) // scratch 的底层数组被复用了
+	// Output:
+	// [1 2 3]
+	// [6 7 8 9]
+	// [6 7 8 9]
+}
+func ExampleStream_SortedDesc() {
+	fmt.Println(stream.OfInts(2, 3, 1).SortedDesc(types.IntComparator).ToSlice())
+	fmt.Println(stream.OfStrings("b", "c", "a").SortedNaturalDesc().ToSlice())
+	fmt.Println(stream.OfFloat64s(0.5, -1, 2.5).SortedNaturalDesc().ToSlice())
+	// Output:
+	// [3 2 1]
+	// [c b a]
+	// [2.5 0.5 -1]
+}
+func ExampleStream_SortedLimit() {
+	stream.OfInts(5, 3, 9, 1, 7, 3, 8).
+		SortedLimit(types.IntComparator, 3).
+		ForEach(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	fmt.Println()
+	fmt.Println(stream.OfInts(2, 1).SortedLimit(types.IntComparator, 5).ToSlice())
+	fmt.Println(stream.OfInts(2, 1).SortedLimit(types.IntComparator, 0).ToSlice())
+	// Output:
+	// 1,3,3,
+	// [1 2]
+	// []
+}
+func ExampleStream_SortedPage() {
+	scores := stream.OfInts(42, 7, 19, 88, 3, 56, 23, 71, 11, 64)
+	// 每页 3 个, 第 2 页
+	fmt.Println(scores.SortedPage(types.IntComparator, 3, 3).ToSlice())
+	fmt.Println(stream.OfInts(3, 1, 2).SortedPage(types.IntComparator, 2, 5).ToSlice())
+	fmt.Println(stream.OfInts(3, 1, 2).SortedPage(types.IntComparator, 5, 5).ToSlice())
+
+	defer func() {
+		fmt.Println(recover() == stream.ErrNegativeSize)
+	}()
+	stream.OfInts(3, 1, 2).SortedPage(types.IntComparator, -1, 5)
+	// Output:
+	// [19 23 42]
+	// [3]
+	// []
+	// true
+}
+func ExampleStream_Limit() {
+	fmt.Println(stream.Repeat(nil).Limit(100).Count())
+	// Output:
+	// 100
+}
+func ExampleStream_LimitWeight() {
+	payloads := []string{"hello", "stream", "go", "a very long payload", "x"}
+	batch := stream.OfStrings(payloads...).
+		LimitWeight(15, func(t types.T) int64 {
+			return int64(len(t.(string)))
+		}).
+		ToSlice()
+	fmt.Println(batch)
+	// 无限流也可以
+	fmt.Println(stream.Repeat("ab").LimitWeight(5, func(t types.T) int64 {
+		return int64(len(t.(string)))
+	}).Count())
+	// Output:
+	// [hello stream go]
+	// 2
+}
+func ExampleStream_TakeUntil() {
+	stream.OfStrings("header", "row1", "row2", "END", "garbage").
+		TakeUntil(func(t types.T) bool {
+			return t == "END"
+		}).
+		ForEach(func(t types.T) {
+			fmt.Printf("%s,", t)
+		})
+	// Output:
+	// header,row1,row2,END,
+}
+func ExampleStream_Skip() {
+	stream.IntRange(0, 10).Skip(5).ForEach(func(t types.T) {
+		fmt.Printf("%d,", t)
+	})
+	// Output:
+	// 5,6,7,8,9,
+}
+
+func ExampleStream_Explain() {
+	s := stream.Of(1, 2, 3).
+		Filter(func(t types.T) bool {
+			return t.(int) > 1
+		}).
+		Map(func(t types.T) types.R {
+			return t.(int) * 2
+		}).
+		Limit(10)
+	fmt.Println(s.Explain())
+	fmt.Printf("%q\n", stream.Of().Explain())
+	// Output:
+	// Filter -> Map -> Limit(10)
+	// ""
+}
+
+func ExampleStream_Buffer() {
+	stream.IntRange(0, 5).
+		Peek(func(t types.T) {
+			fmt.Printf("read %d\n", t)
+		}).
+		Buffer(2).
+		ForEach(func(t types.T) {
+			fmt.Printf("write %d\n", t)
+		})
+	// Output:
+	// read 0
+	// read 1
+	// write 0
+	// write 1
+	// read 2
+	// read 3
+	// write 2
+	// write 3
+	// read 4
+	// write 4
+}
+
+func ExampleStream_Skip_negative() {
+	s := stream.OfInts(1, 2, 3).Skip(-2)
+	fmt.Println(s.Explain())
+	stream.OfInts(1, 2, 3).Skip(-2).ReduceBy(func(sizeMayNegative int64) types.R {
+		fmt.Printf("size=%d\n", sizeMayNegative)
+		return nil
+	}, func(acc types.R, e types.T) types.R {
+		return nil
+	})
+	fmt.Println(stream.OfInts(1, 2, 3).Skip(-2).ToSlice())
+	fmt.Println(stream.OfInts(1, 2, 3).Skip(5).ToSlice())
+	// Output:
+	// Skip(0)
+	// size=3
+	// [1 2 3]
+	// []
+}
+func ExampleStream_SkipWhile() {
+	// 跳过开头的注释行
+	stream.OfStrings("# header", "# comment", "a", "# not leading", "b").
+		SkipWhile(func(t types.T) bool {
+			return strings.HasPrefix(t.(string), "#")
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	fmt.Println(stream.OfInts(1, 2, 3, 4).SkipN(2).ToSlice())
+	// Output:
+	// a
+	// # not leading
+	// b
+	// [3 4]
+}
+func ExampleStream_WindowBy() {
+	stream.OfStrings("apple", "avocado", "banana", "cherry", "coconut", "apricot").
+		WindowBy(func(t types.T) types.R {
+			return t.(string)[0]
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	// Output:
+	// [apple avocado]
+	// [banana]
+	// [cherry coconut]
+	// [apricot]
+}
+
+func ExampleStream_SplitAt() {
+	lines := stream.FromLines(strings.NewReader("name,age\nalice,20\nbob,18\n")).
+		OnClose(func() {
+			fmt.Println("closed")
+		})
+	header, rows := lines.SplitAt(1)
+	fmt.Println(header.ToSlice())
+	rows.ForEach(func(t types.T) {
+		fmt.Println(strings.Split(t.(string), ","))
+	})
+
+	// 先消费 tail 时, head 中的元素被跳过
+	head, tail := stream.IntRange(0, 5).SplitAt(2)
+	fmt.Println(tail.ToSlice(), head.ToSlice())
+	// Output:
+	// [name,age]
+	// [alice 20]
+	// [bob 18]
+	// closed
+	// [2 3 4] []
+}
+
+func ExampleStream_ChunkOf() {
+	type record struct {
+		ID int
+	}
+	bulkInsert := func(records []*record) {
+		fmt.Printf("insert %d records:", len(records))
+		for _, r := range records {
+			if r == nil {
+				fmt.Print(" nil")
+			} else {
+				fmt.Print(" ", r.ID)
+			}
+		}
+		fmt.Println()
+	}
+	stream.Of(&record{1}, &record{2}, nil, &record{4}, &record{5}).
+		ChunkOf(reflect.TypeOf(&record{}), 2).
+		ForEach(func(t types.T) {
+			bulkInsert(t.([]*record))
+		})
+	fmt.Println(stream.IntRange(0, 5).ChunkOf(reflect.TypeOf(0), 3).ToSlice())
+	// Output:
+	// insert 2 records: 1 2
+	// insert 2 records: nil 4
+	// insert 1 records: 5
+	// [[0 1 2] [3 4]]
+}
+
+func ExampleStream_WindowReduce() {
+	mean := func(window []types.T) types.R {
+		sum := 0.0
+		for _, t := range window {
+			sum += t.(float64)
+		}
+		return sum / float64(len(window))
+	}
+	fmt.Println(stream.OfFloat64s(1, 2, 3, 4, 5).WindowReduce(3, 1, false, mean).ToSlice())
+	fmt.Println(stream.OfFloat64s(1, 2, 3, 4, 5).WindowReduce(3, 2, true, mean).ToSlice())
+	fmt.Println(stream.OfFloat64s(1, 2, 3, 4, 5, 6, 7).WindowReduce(2, 3, true, mean).ToSlice())
+	// Output:
+	// [2 3 4]
+	// [2 4 5]
+	// [1.5 4.5 7]
+}
+
+func ExampleStream_GroupByToStream() {
+	stream.OfStrings("apple", "banana", "avocado", "cherry", "apricot", "coconut").
+		GroupByToStream(func(t types.T) types.R {
+			return string(t.(string)[0])
+		}).
+		Filter(func(t types.T) bool {
+			return len(t.(types.Pair).Second.([]types.T)) > 1 // 去掉只有一个元素的组
+		}).
+		Sorted(func(left, right types.T) int {
+			return len(right.(types.Pair).Second.([]types.T)) - len(left.(types.Pair).Second.([]types.T))
+		}).
+		ForEach(func(t types.T) {
+			fmt.Println(t)
+		})
+	// Output:
+	// {a [apple avocado apricot]}
+	// {c [cherry coconut]}
+}
+
+func ExampleStream_ForEach() {
+	stream.Of("hello", "world").ForEach(func(t types.T) {
+		fmt.Println(t)
+	})
+	// Output:
+	// hello
+	// world
+}
+func ExampleStream_ForEachOrdered() {
+	stream.IntRange(0, 5).ForEachOrdered(func(t types.T) {
+		fmt.Printf("%d,", t)
+	})
+	// Output:
+	// 0,1,2,3,4,
+}
+func ExampleStream_ForEachUntil() {
+	budget := 10
+	stream.Iterate(1, func(t types.T) types.T {
+		return t.(int) + 1
+	}).ForEachUntil(func(t types.T) bool {
+		budget -= t.(int)
+		fmt.Printf("%d(budget %d),", t, budget)
+		return budget > 0
+	})
+	// Output:
+	// 1(budget 9),2(budget 7),3(budget 4),4(budget 0),
+}
+func ExampleStream_ForEachCount() {
+	n := stream.IntRange(0, 10).
+		Filter(func(t types.T) bool {
+			return t.(int)%4 == 0
+		}).
+		ForEachCount(func(t types.T) {
+			fmt.Printf("%d,", t)
+		})
+	fmt.Printf("\n%d\n", n)
+	// Output:
+	// 0,4,8,
+	// 3
+}
+func ExampleStream_ForEachBatch() {
+	var batches [][]types.T
+	stream.IntRange(0, 7).ForEachBatch(3, func(batch []types.T) {
+		fmt.Println("insert", batch)
+		batches = append(batches, batch) // 每一批都是新的切片, 可以保留
+	})
+	fmt.Println(batches)
+
+	defer func() {
+		fmt.Println(recover() == stream.ErrNonPositiveSize)
+	}()
+	stream.IntRange(0, 7).ForEachBatch(0, func([]types.T) {})
+	// Output:
+	// insert [0 1 2]
+	// insert [3 4 5]
+	// insert [6]
+	// [[0 1 2] [3 4 5] [6]]
+	// true
+}
+func ExampleStream_ToSlice() {
+	slice := stream.Of(1, 2, 3).ToSlice()
+	fmt.Printf("%#v\n", slice)
+	// Output:
+	// []types.T{1, 2, 3}
+}
+func ExampleStream_ToSliceInto() {
+	buf := make([]types.T, 0, 8)
+	for _, n := range []int{3, 5} {
+		buf = stream.IntRange(0, n).ToSliceInto(buf[:0])
+		fmt.Println(buf, cap(buf))
+	}
+	fmt.Println(stream.Of(3, 4).ToSliceInto([]types.T{1, 2}))
+	// Output:
+	// [0 1 2] 8
+	// [0 1 2 3 4] 8
+	// [1 2 3 4]
+}
+func ExampleStream_SetSlice() {
+	byMod3 := func(t types.T) int {
+		return t.(int) % 3
+	}
+	fmt.Println(stream.OfInts(4, 1, 3, 7, 6, 5).SetSlice(byMod3))
+	empty := stream.Of().SetSlice(byMod3)
+	fmt.Println(empty, empty != nil)
+	// Output:
+	// [4 3 5]
+	// [] true
+}
+func ExampleStream_ToElementSlice() {
+	slice := stream.Of(1, 2, 3).ToElementSlice(0)
+	fmt.Printf("%#v\n", slice)
+	// Output:
+	// []int{1, 2, 3}
+}
+func ExampleStream_ToSliceOf() {
+	slice := stream.Of(1, 2, 3).ToSliceOf(reflect.TypeOf(0))
+	fmt.Printf("%#v\n", slice)
+	// Output:
+	// []int{1, 2, 3}
+}
+func ExampleStream_PairsToMap() {
+	m := stream.OfMap(map[string]int{"a": 1, "b": 2, "c": 3}).
+		Filter(func(t types.T) bool {
+			return t.(types.Pair).Second.(int) > 1
+		}).
+		MapValues(func(t types.T) types.R {
+			return t.(int) * 10
+		}).
+		PairsToMap()
+	fmt.Println(m)
+	fmt.Println(stream.Of(types.NewPair("k", 1), types.NewPair("k", 2)).PairsToMap())
+	fmt.Println(stream.Of().PairsToMap() != nil)
+	// Output:
+	// map[b:20 c:30]
+	// map[k:2]
+	// true
+}
+
+func ExampleStream_PartitionErrors() {
+	values, errs := stream.OfStrings("1", "x", "3", "").
+		Map(func(t types.T) types.R {
+			i, err := strconv.Atoi(t.(string))
+			return types.Pair{First: i, Second: err}
+		}).
+		PartitionErrors()
+	fmt.Println(values)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	values, errs = stream.Of().PartitionErrors()
+	fmt.Println(values != nil, errs != nil)
+	// Output:
+	// [1 3]
+	// strconv.Atoi: parsing "x": invalid syntax
+	// strconv.Atoi: parsing "": invalid syntax
+	// true true
+}
+func ExampleStream_ToMapReducing() {
+	type order struct {
+		Customer string
+		Total    float64
+	}
+	totals := stream.Of(order{"alice", 10}, order{"bob", 5}, order{"alice", 2.5}).
+		ToMapReducing(func(t types.T) types.R {
+			return t.(order).Customer
+		}, func(t types.T) types.R {
+			return t.(order).Total
+		}, func(acc types.T, t types.T) types.T {
+			return acc.(float64) + t.(float64)
+		})
+	fmt.Println(totals)
+	fmt.Println(len(stream.Of().ToMapReducing(nil, nil, nil)))
+	// Output:
+	// map[alice:12.5 bob:5]
+	// 0
+}
+func ExampleStream_GroupByThen() {
+	parity := func(t types.T) types.R {
+		if t.(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+	counts := stream.IntRange(0, 5).GroupByThen(parity, stream.CountingCollector())
+	fmt.Println(counts)
+	groups := stream.IntRange(0, 5).GroupByThen(parity, stream.ToSliceCollector())
+	fmt.Println(groups)
+	// Output:
+	// map[even:3 odd:2]
+	// map[even:[0 2 4] odd:[1 3]]
+}
+
+func ExampleStream_Teeing_statistics() {
+	type report struct {
+		Rows  []types.T
+		Stats *stream.SummaryStatistics
+	}
+	toFloat := func(t types.T) float64 {
+		return float64(t.(int))
+	}
+	r := stream.OfInts(3, 1, 4, 1, 5).Teeing(stream.ToSliceCollector(), stream.SummaryStatisticsCollector(toFloat),
+		func(rows, stats types.R) types.R {
+			return report{Rows: rows.([]types.T), Stats: stats.(*stream.SummaryStatistics)}
+		}).(report)
+	fmt.Println(r.Rows)
+	fmt.Printf("%+v avg=%.1f\n", *r.Stats, r.Stats.Average())
+	// Output:
+	// [3 1 4 1 5]
+	// {Count:5 Sum:14 Min:1 Max:5} avg=2.8
+}
+
+func ExampleStream_Async() {
+	out, errs := stream.IntRange(0, 5).Async(context.Background(), 2)
+	for t := range out {
+		fmt.Printf("%d,", t)
+	}
+	fmt.Println(<-errs)
+
+	out, errs = stream.Of(1, 0).Map(func(t types.T) types.R {
+		return 1 / t.(int)
+	}).Async(context.Background(), 0)
+	for t := range out {
+		fmt.Printf("%d,", t)
+	}
+	fmt.Println(<-errs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errs = stream.Repeat(1).Async(ctx, 0)
+	fmt.Println(<-out)
+	cancel()
+	for range out {
+	}
+	fmt.Println(<-errs)
+	// Output:
+	// 0,1,2,3,4,<nil>
+	// 1,runtime error: integer divide by zero
+	// 1
+	// context canceled
+}
+
+func ExampleStream_JoiningMap() {
+	itoa := func(t types.T) types.R {
+		return strconv.Itoa(t.(int))
+	}
+	fmt.Println(stream.IntRange(0, 5).JoiningMap(itoa, ","))
+	fmt.Printf("%q\n", stream.Of().JoiningMap(itoa, ","))
+	// Output:
+	// 0,1,2,3,4
+	// ""
+}
+func ExampleStream_JoiningLimit() {
+	itoa := func(t types.T) types.R {
+		return strconv.Itoa(t.(int))
+	}
+	fmt.Println(stream.IntRange(0, 5).JoiningLimit(itoa, ",", 3, ",..."))
+	fmt.Println(stream.IntRange(0, 3).JoiningLimit(itoa, ",", 3, ",..."))
+	fmt.Printf("%q\n", stream.Of().JoiningLimit(itoa, ",", 3, ",..."))
+	fmt.Printf("%q\n", stream.Of(1).JoiningLimit(itoa, ",", 0, "..."))
+	// 无限流也可以, 取到第 4 个元素后就结束了
+	fmt.Println(stream.Iterate(0, func(t types.T) types.T {
+		return t.(int) + 1
+	}).JoiningLimit(itoa, " ", 3, " and more"))
+	// Output:
+	// 0,1,2,...
+	// 0,1,2
+	// ""
+	// "..."
+	// 0 1 2 and more
+}
+func ExampleStream_ToJSON() {
+	type dto struct {
+		Name string `json:"name"`
+	}
+	var sb strings.Builder
+	err := stream.OfStrings("a", "b").Map(func(t types.T) types.R {
+		return dto{Name: t.(string)}
+	}).ToJSON(&sb)
+	fmt.Println(sb.String(), err)
+	sb.Reset()
+	err = stream.Of().ToJSON(&sb)
+	fmt.Println(sb.String(), err)
+	sb.Reset()
+	err = stream.Of(1, func() {}, 2).ToJSON(&sb)
+	fmt.Println(sb.String(), err)
+	// Output:
+	// [{"name":"a"},{"name":"b"}] <nil>
+	// [] <nil>
+	// [1 json: unsupported type: func()
+}
+
+type limitedWriter struct {
+	remaining int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		n := w.remaining
+		w.remaining = 0
+		return n, errors.New("no space left")
+	}
+	w.remaining -= len(p)
+	fmt.Printf("%s", p)
+	return len(p), nil
+}
+
+func ExampleStream_ToWriter() {
+	line := func(t types.T) []byte {
+		return []byte(fmt.Sprintf("%d\n", t))
+	}
+	n, err := stream.IntRange(0, 3).ToWriter(&limitedWriter{remaining: 100}, line)
+	fmt.Println(n, err)
+	n, err = stream.IntRange(0, 100).Peek(func(t types.T) {
+		fmt.Printf("peek %d\n", t)
+	}).ToWriter(&limitedWriter{remaining: 5}, line)
+	fmt.Println(n, err)
+	// Output:
+	// 0
+	// 1
+	// 2
+	// 6 <nil>
+	// peek 0
+	// 0
+	// peek 1
+	// 1
+	// peek 2
+	// 5 no space left
+}
+
+func ExampleStream_ToMapOf() {
+	m := stream.OfStrings("a", "bb", "cc").ToMapOf(reflect.TypeOf(0), reflect.TypeOf(""), func(t types.T) types.R {
+		return len(t.(string))
+	}, func(t types.T) types.R {
+		return t
+	})
+	fmt.Printf("%#v\n", m)
+	errs := stream.Of("ok", "bad").ToMapOf(reflect.TypeOf(""), reflect.TypeOf((*error)(nil)).Elem(), func(t types.T) types.R {
+		return t
+	}, func(t types.T) types.R {
+		if t == "bad" {
+			return errors.New("bad")
+		}
+		return nil
+	}).(map[string]error)
+	fmt.Println(errs["ok"], errs["bad"])
+	// Output:
+	// map[int]string{1:"a", 2:"cc"}
+	// <nil> bad
+}
+func ExampleStream_AllMatch() {
+	allMatch := stream.IntRange(0, 10).AllMatch(func(t types.T) bool {
+		i, ok := t.(int)
+		return ok && i >= 0 && i < 10
+	})
+	fmt.Println(allMatch)
+	allMatch = stream.IntRange(0, 10).AllMatch(func(t types.T) bool {
+		i, ok := t.(int)
+		return ok && i > 0 && i < 10
+	})
+	fmt.Println(allMatch)
+	noElementSoResultShouldBeFalse := stream.Of().AnyMatch(func(t types.T) bool {
+		return true
+	})
+	fmt.Println(noElementSoResultShouldBeFalse)
+	// Output:
+	// true
 	// false
 	// false
 }
@@ -428,6 +1791,21 @@ func ExampleStream_AnyMatch() {
 	// false
 }
 
+func ExampleStream_AnyMatchIndexed() {
+	isNegativeAfter := func(k int64) func(index int64, t types.T) bool {
+		return func(index int64, t types.T) bool {
+			return index > k && t.(int) < 0
+		}
+	}
+	fmt.Println(stream.OfInts(-1, 2, 3, -4).AnyMatchIndexed(isNegativeAfter(2)))
+	fmt.Println(stream.OfInts(-1, 2, 3, -4).AnyMatchIndexed(isNegativeAfter(3)))
+	fmt.Println(stream.Of().AnyMatchIndexed(isNegativeAfter(0)))
+	// Output:
+	// true
+	// false
+	// false
+}
+
 func ExampleStream_Reduce() {
 	fmt.Println(stream.Of().Reduce(func(acc types.T, t types.T) types.T {
 		return acc
@@ -435,6 +1813,44 @@ func ExampleStream_Reduce() {
 	// Output:
 	// false
 }
+func ExampleStream_ReduceOk() {
+	add := func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	}
+	if v, ok := stream.IntRange(0, 5).ReduceOk(add); ok {
+		fmt.Println(v)
+	}
+	fmt.Println(stream.Of().ReduceOk(add))
+	// Output:
+	// 10
+	// <nil> false
+}
+func ExampleStream_ReduceCounting() {
+	sum, count := stream.OfInts(3, 5, 10).ReduceCounting(func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	})
+	fmt.Println(sum.Get(), count, float64(sum.Get().(int))/float64(count))
+	sum, count = stream.Of().ReduceCounting(func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	})
+	fmt.Println(sum.IsPresent(), count)
+	// Output:
+	// 18 3 6
+	// false 0
+}
+
+func ExampleStream_ReduceRight() {
+	nest := func(e types.T, acc types.T) types.T {
+		return fmt.Sprintf("%s(%s)", e, acc)
+	}
+	fmt.Println(stream.OfStrings("f", "g", "x").ReduceRight(nest).Get())
+	fmt.Println(stream.OfStrings("f", "g", "x").Reduce(nest).Get())
+	fmt.Println(stream.Of().ReduceRight(nest).IsPresent())
+	// Output:
+	// f(g(x))
+	// f(g)(x)
+	// false
+}
 func ExampleStream_ReduceFrom() {
 	sum := stream.IntRange(1, 101).ReduceFrom(0, func(acc types.T, t types.T) types.T {
 		return acc.(int) + t.(int)
@@ -448,6 +1864,32 @@ func ExampleStream_ReduceFrom() {
 	// 5050
 	// 24
 }
+func ExampleStream_ReduceIdentity() {
+	add := func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	}
+	fmt.Println(stream.IntRange(1, 101).ReduceIdentity(0, add, add))
+	fmt.Println(stream.Of().ReduceIdentity(0, add, add))
+	// Output:
+	// 5050
+	// 0
+}
+func ExampleStream_ParallelReduce() {
+	add := func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	}
+	fmt.Println(stream.IntRange(1, 101).ParallelReduce(4, 0, add, add))
+	fmt.Println(stream.Of().ParallelReduce(4, 0, add, add))
+	// 字符串拼接满足结合律但不满足交换律, 部分结果按顺序合并
+	concat := func(acc types.T, t types.T) types.T {
+		return acc.(string) + t.(string)
+	}
+	fmt.Println(stream.OfStrings("a", "b", "c", "d", "e").ParallelReduce(3, "", concat, concat))
+	// Output:
+	// 5050
+	// 0
+	// abcde
+}
 func ExampleStream_ReduceWith() {
 	slice := stream.IntRange(0, 10).ReduceWith(make([]int, 0, 10), func(acc types.R, t types.T) types.R {
 		return append(acc.([]int), t.(int))
@@ -488,6 +1930,61 @@ func ExampleStream_ReduceBy() {
 	// [0 1 2 3 4 5 6 7 8 9]
 }
 
+func ExampleStream_Last() {
+	fmt.Println(stream.IntRange(0, 10).Last().Get())
+	fmt.Println(stream.Of().Last().IsPresent())
+	// Output:
+	// 9
+	// false
+}
+
+func ExampleStream_FindAny() {
+	found := stream.IntRange(1, 100).
+		Filter(func(t types.T) bool {
+			return t.(int)%7 == 0
+		}).
+		FindAny()
+	fmt.Println(found.IsPresent(), found.Get().(int)%7)
+	fmt.Println(stream.Of().FindAny().IsPresent())
+	// Output:
+	// true 0
+	// false
+}
+func ExampleStream_TopN() {
+	scores := stream.OfInts(70, 95, 60, 88, 100, 75)
+	fmt.Println(scores.TopN(3, types.IntComparator))
+	fmt.Println(stream.OfInts(1, 2).TopN(3, types.IntComparator))
+	fmt.Printf("%#v\n", stream.Of().TopN(3, types.IntComparator))
+	// Output:
+	// [100 95 88]
+	// [2 1]
+	// []types.T{}
+}
+
+func ExampleStream_MinMax() {
+	min, max := stream.OfInts(3, 1, 4, 1, 5, 9, 2, 6).MinMax(types.IntComparator)
+	fmt.Println(min.Get(), max.Get())
+	min, max = stream.Of().MinMax(types.IntComparator)
+	fmt.Println(min.IsPresent(), max.IsPresent())
+	// Output:
+	// 1 9
+	// false false
+}
+
+func ExampleStream_Head() {
+	fmt.Printf("%#v\n", stream.Iterate(1, func(t types.T) types.T {
+		return t.(int) * 2
+	}).Head(5))
+	fmt.Printf("%#v\n", stream.Of(1, 2).Head(5))
+	fmt.Printf("%#v\n", stream.Of().Head(5))
+	fmt.Printf("%#v\n", stream.Of(1, 2).Head(0))
+	// Output:
+	// []types.T{1, 2, 4, 8, 16}
+	// []types.T{1, 2}
+	// []types.T{}
+	// []types.T{}
+}
+
 func ExampleStream_Count() {
 	fmt.Println(stream.Of().Count())
 	fmt.Println(stream.Of(1).Count())
@@ -498,6 +1995,41 @@ func ExampleStream_Count() {
 	// 2
 }
 
+func ExampleStream_Count_knownSize() {
+	entries := []types.Pair{{First: "a", Second: 1}, {First: "b", Second: 2}}
+	fmt.Println(stream.OfSlice(entries).Keys().Count()) // no traversal
+	compared := 0
+	count := stream.OfInts(3, 1, 2).Sorted(func(left, right types.T) int {
+		compared++
+		return left.(int) - right.(int)
+	}).Count() // Sorted calls the comparator, so it traverses
+	fmt.Println(count, compared > 0)
+	fmt.Println(stream.OfInts(3, 1, 2).Peek(func(t types.T) {
+		fmt.Printf("%d,", t)
+	}).Count()) // Peek has side effects, so it traverses
+	// Output:
+	// 2
+	// 3 true
+	// 3,1,2,3
+}
+
+func ExampleStream_Collect() {
+	slice := stream.IntRange(0, 5).Collect(stream.ToSliceCollector())
+	fmt.Printf("%#v\n", slice)
+	// Output:
+	// []types.T{0, 1, 2, 3, 4}
+}
+func ExampleStream_Teeing() {
+	result := stream.IntRange(1, 11).Teeing(stream.CountingCollector(), stream.ReducingCollector(0, func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	}), func(count, sum types.R) types.R {
+		return fmt.Sprintf("count=%d, sum=%d", count, sum)
+	})
+	fmt.Println(result)
+	// Output:
+	// count=10, sum=55
+}
+
 type person struct {
 	name string
 	age  int
@@ -537,3 +2069,382 @@ func TestToMap(t *testing.T) {
 	// Output:
 	// map[0:0 1:10 2:20 3:30 4:40 5:50 6:60 7:70 8:80 9:90]
 }
+func TestToSliceOfNil(t *testing.T) {
+	a, c := &person{name: "a"}, &person{name: "c"}
+	slice := stream.Of(a, nil, c, nil).ToSliceOf(reflect.TypeOf(a)).([]*person)
+	if len(slice) != 4 || slice[0] != a || slice[1] != nil || slice[2] != c || slice[3] != nil {
+		t.Errorf("unexpected slice: %v", slice)
+	}
+	errs := stream.Of(nil, errors.New("e")).ToSliceOf(reflect.TypeOf((*error)(nil)).Elem()).([]error)
+	if len(errs) != 2 || errs[0] != nil || errs[1].Error() != "e" {
+		t.Errorf("unexpected slice: %v", errs)
+	}
+}
+
+func TestSizeHintBoundary(t *testing.T) {
+	hint := func(s stream.Stream) (size int64) {
+		s.PeekLifecycle(func(n int64) {
+			size = n
+		}, nil, nil).Count()
+		return
+	}
+	cases := []struct {
+		name string
+		s    stream.Stream
+		want int64
+	}{
+		{"Limit(-1)", stream.Of(1, 2, 3).Limit(-1), 0},
+		{"Limit(0)", stream.Of(1, 2, 3).Limit(0), 0},
+		{"Limit(MaxInt64)", stream.Of(1, 2, 3).Limit(math.MaxInt64), 3},
+		{"Skip(MaxInt64)", stream.Of(1, 2, 3).Skip(math.MaxInt64), 0},
+		{"Skip(MinInt64)", stream.Of(1, 2, 3).Skip(math.MinInt64), 3},
+		{"Skip(MaxInt64).Limit(MaxInt64)", stream.Of(1, 2, 3).Skip(math.MaxInt64).Limit(math.MaxInt64), 0},
+		{"Skip(1).Limit(MinInt64)", stream.Of(1, 2, 3).Skip(1).Limit(math.MinInt64), 0},
+		{"Filter.Limit(MaxInt64)", stream.Of(1, 2, 3).Filter(func(types.T) bool { return true }).Limit(math.MaxInt64), -1},
+	}
+	for _, c := range cases {
+		if got := hint(c.s); got != c.want {
+			t.Errorf("%s: size hint = %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	// 过大的 limit 不应该导致预分配失败
+	maxInt := int(^uint(0) >> 1)
+	if got := stream.Of(1, 2).Head(maxInt); !reflect.DeepEqual(got, []types.T{1, 2}) {
+		t.Errorf("Head(MaxInt) = %v", got)
+	}
+	if got := stream.Of(1, 2).Buffer(maxInt).ToSlice(); !reflect.DeepEqual(got, []types.T{1, 2}) {
+		t.Errorf("Buffer(MaxInt) = %v", got)
+	}
+	if got := stream.Of(1, 2).Buffer(maxInt).Count(); got != 2 {
+		t.Errorf("Buffer(MaxInt).Count() = %d", got)
+	}
+	unknown := func() stream.Stream {
+		return stream.Of(3, 1, 2).Filter(func(types.T) bool { return true })
+	}
+	if got := unknown().SortedLimit(types.IntComparator, math.MaxInt64).ToSlice(); !reflect.DeepEqual(got, []types.T{1, 2, 3}) {
+		t.Errorf("SortedLimit(MaxInt64) = %v", got)
+	}
+	// 元素个数未知时, 预分配也不能超过 maxPreallocSize
+	if got := unknown().Head(maxInt); !reflect.DeepEqual(got, []types.T{3, 1, 2}) {
+		t.Errorf("unknown size Head(MaxInt) = %v", got)
+	}
+	if got := unknown().Buffer(maxInt).ToSlice(); !reflect.DeepEqual(got, []types.T{3, 1, 2}) {
+		t.Errorf("unknown size Buffer(MaxInt) = %v", got)
+	}
+}
+
+// 一个耗 CPU 的 accumulator: 累加 t 的各位数字的若干次平方和
+func cpuBoundAdd(acc types.T, t types.T) types.T {
+	n := t.(int)
+	for i := 0; i < 200; i++ {
+		sum := 0
+		for m := n + i; m > 0; m /= 10 {
+			sum += (m % 10) * (m % 10)
+		}
+		n = sum
+	}
+	return acc.(int) + n
+}
+
+func add(acc types.T, t types.T) types.T {
+	return acc.(int) + t.(int)
+}
+
+func BenchmarkReduceIdentity(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		stream.IntRange(0, 10000).ReduceIdentity(0, cpuBoundAdd, add)
+	}
+}
+
+func BenchmarkParallelReduce(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		stream.IntRange(0, 10000).ParallelReduce(runtime.NumCPU(), 0, cpuBoundAdd, add)
+	}
+}
+
+func TestCycleSizeHint(t *testing.T) {
+	hint := func(s stream.Stream) (size int64) {
+		s.OnStart(func(n int64) {
+			size = n
+		}).Limit(5).Count()
+		return
+	}
+	two := []types.T{1, 2}
+	cases := []struct {
+		name string
+		s    stream.Stream
+		want int64
+	}{
+		{"finite", stream.Cycle(two, 3), 6},
+		{"infinite", stream.Cycle(two, -1), -1},
+		{"empty", stream.Cycle(nil, 3), 0},
+	}
+	maxInt := int(^uint(0) >> 1)
+	want := int64(math.MaxInt64) // 64 位平台上 2*maxInt 溢出了
+	if strconv.IntSize == 32 {
+		want = 2 * int64(maxInt)
+	}
+	cases = append(cases, struct {
+		name string
+		s    stream.Stream
+		want int64
+	}{"overflow", stream.Cycle(two, maxInt), want})
+	for _, c := range cases {
+		if got := hint(c.s); got != c.want {
+			t.Errorf("%s: size hint = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func BenchmarkDistinct(b *testing.B) {
+	ints := make([]int, 100000)
+	for i := range ints {
+		ints[i] = i
+	}
+	identity := func(t types.T) int {
+		return t.(int)
+	}
+	b.Run("known size", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			stream.OfInts(ints...).Distinct(identity).Count()
+		}
+	})
+	b.Run("unknown size", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			stream.OfInts(ints...).Filter(func(types.T) bool {
+				return true
+			}).Distinct(identity).Count()
+		}
+	})
+}
+
+func BenchmarkToSliceInto(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]types.T, 0, 16)
+	for i := 0; i < b.N; i++ {
+		buf = stream.OfInts(1, 2, 3, 4, 5, 6, 7, 8).ToSliceInto(buf[:0])
+	}
+}
+
+func TestMatchOnEmptyStream(t *testing.T) {
+	never := func(types.T) bool {
+		t.Error("predicate called on an empty stream")
+		return false
+	}
+	cases := []struct {
+		name string
+		s    func() stream.Stream
+	}{
+		{"Of()", func() stream.Stream { return stream.Of() }},
+		{"OfInts()", func() stream.Stream { return stream.OfInts() }},
+		{"Filter", func() stream.Stream {
+			return stream.Of(1, 2).Filter(func(types.T) bool { return false })
+		}},
+		{"Limit(0)", func() stream.Stream { return stream.Of(1, 2).Limit(0) }},
+		{"Sorted", func() stream.Stream { return stream.Of().Sorted(types.IntComparator) }},
+		{"infinite Limit(0)", func() stream.Stream { return stream.Repeat(1).Limit(0) }},
+		{"empty Cycle", func() stream.Stream { return stream.Cycle(nil, -1) }},
+	}
+	for _, c := range cases {
+		if !c.s().AllMatch(never) {
+			t.Errorf("%s: AllMatch = false, want true", c.name)
+		}
+		if !c.s().NoneMatch(never) {
+			t.Errorf("%s: NoneMatch = false, want true", c.name)
+		}
+		if c.s().AnyMatch(never) {
+			t.Errorf("%s: AnyMatch = true, want false", c.name)
+		}
+	}
+}
+
+func TestGeneratorStopsProducer(t *testing.T) {
+	exited := make(chan int)
+	emitted := 0
+	got := stream.Generator(func(emit types.Consumer) {
+		defer func() {
+			exited <- emitted
+		}()
+		for i := 0; ; i++ {
+			emit(i)
+			emitted++
+		}
+	}).Limit(3).ToSlice()
+	if !reflect.DeepEqual(got, []types.T{0, 1, 2}) {
+		t.Errorf("got %v", got)
+	}
+	select {
+	case n := <-exited:
+		if n > 4 {
+			t.Errorf("producer emitted %d elements after stop", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("producer goroutine didn't exit")
+	}
+}
+
+func TestPeekOrderAroundSorted(t *testing.T) {
+	var events []string
+	peek := func(prefix string) types.Consumer {
+		return func(e types.T) {
+			events = append(events, prefix+strconv.Itoa(e.(int)))
+		}
+	}
+	cases := []struct {
+		name string
+		s    func() stream.Stream
+		want []string
+	}{
+		{"Sorted", func() stream.Stream {
+			return stream.OfInts(3, 1, 2).Peek(peek("before")).Sorted(types.IntComparator).Peek(peek("after"))
+		}, []string{"before3", "before1", "before2", "after1", "after2", "after3"}},
+		{"SortedInto", func() stream.Stream {
+			return stream.OfInts(3, 1, 2).Peek(peek("before")).SortedInto(types.IntComparator, nil).Peek(peek("after"))
+		}, []string{"before3", "before1", "before2", "after1", "after2", "after3"}},
+		{"SortedLimit", func() stream.Stream {
+			return stream.OfInts(3, 1, 2).Peek(peek("before")).SortedLimit(types.IntComparator, 2).Peek(peek("after"))
+		}, []string{"before3", "before1", "before2", "after1", "after2"}},
+		{"Sorted then Limit", func() stream.Stream {
+			return stream.OfInts(3, 1, 2).Peek(peek("before")).Sorted(types.IntComparator).Peek(peek("after")).Limit(1)
+		}, []string{"before3", "before1", "before2", "after1"}},
+		{"Map and Filter", func() stream.Stream {
+			return stream.OfInts(3, 1, 2).Peek(peek("before")).Map(func(e types.T) types.R {
+				return e.(int) * 10
+			}).Filter(func(e types.T) bool {
+				return e.(int) != 10
+			}).Peek(peek("after"))
+		}, []string{"before3", "after30", "before1", "before2", "after20"}},
+	}
+	for _, c := range cases {
+		events = nil
+		c.s().ForEach(func(types.T) {})
+		if !reflect.DeepEqual(events, c.want) {
+			t.Errorf("%s: side effects %v, want %v", c.name, events, c.want)
+		}
+	}
+}
+
+func TestMergeByKeyPullsLazily(t *testing.T) {
+	pulled := 0
+	source := func(elements ...int) stream.Stream {
+		return stream.OfInts(elements...).Peek(func(types.T) {
+			pulled++
+		})
+	}
+	self := func(t types.T) types.R {
+		return t
+	}
+	merged := stream.MergeByKey([]stream.Stream{source(1, 4, 7), source(2, 5, 8), source(3, 6, 9)}, self, types.IntComparator)
+	if pulled != 0 {
+		t.Fatalf("pulled %d elements before the terminal operate", pulled)
+	}
+	if got := merged.Limit(2).ToSlice(); !reflect.DeepEqual(got, []types.T{1, 2}) {
+		t.Fatalf("got %v, want [1 2]", got)
+	}
+	// 每个流的当前元素, 加上替换已发送元素的两个
+	if pulled > 5 {
+		t.Errorf("pulled %d elements to emit 2, want at most 5", pulled)
+	}
+}
+
+func TestCountCallsComparator(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Count on Sorted with an incomparable element should panic")
+		}
+	}()
+	stream.Of(1, "a").Sorted(types.IntComparator).Count()
+}
+
+func TestSortedPageEmptyLimit(t *testing.T) {
+	compared := 0
+	cmp := func(left, right types.T) int {
+		compared++
+		return left.(int) - right.(int)
+	}
+	if got := stream.IntRange(0, 100).SortedPage(cmp, 1000000, 0).ToSlice(); len(got) != 0 {
+		t.Errorf("got %v, want empty", got)
+	}
+	if compared != 0 {
+		t.Errorf("comparator called %d times for a empty page", compared)
+	}
+}
+
+func TestCountCallsSourceFunc(t *testing.T) {
+	calls := 0
+	get := func() types.R {
+		calls++
+		return calls
+	}
+	if got := stream.GenerateN(get, 3).Count(); got != 3 || calls != 3 {
+		t.Errorf("GenerateN.Count() = %d with %d supplier calls, want 3 and 3", got, calls)
+	}
+	calls = 0
+	next := func(t types.T) types.T {
+		calls++
+		return t
+	}
+	if got := stream.IterateN(0, next, 3).Enumerate().Count(); got != 3 || calls != 2 {
+		t.Errorf("IterateN.Count() = %d with %d operator calls, want 3 and 2", got, calls)
+	}
+	calls = 0
+	if got := stream.ConcatAll(stream.GenerateN(get, 2), stream.OfInts(1)).Count(); got != 3 || calls != 2 {
+		t.Errorf("ConcatAll(GenerateN).Count() = %d with %d supplier calls, want 3 and 2", got, calls)
+	}
+}
+
+func TestMergeByKeyCountCallsKeyFn(t *testing.T) {
+	calls := 0
+	keyFn := func(t types.T) types.R {
+		calls++
+		return t
+	}
+	merged := stream.MergeByKey([]stream.Stream{stream.OfInts(1, 3), stream.OfInts(2)}, keyFn, types.IntComparator)
+	if got := merged.Count(); got != 3 || calls != 3 {
+		t.Errorf("Count() = %d with %d keyFn calls, want 3 and 3", got, calls)
+	}
+}
+
+func TestMapConcurrentNilPanic(t *testing.T) {
+	for _, name := range []string{"panic(nil)", "Goexit"} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Errorf("%s: want a panic", name)
+				} else if err, ok := r.(error); ok && name == "Goexit" && !errors.Is(err, stream.ErrNilPanic) {
+					t.Errorf("%s: recovered %v, want ErrNilPanic", name, r)
+				}
+			}()
+			stream.OfInts(1, 2, 3).MapConcurrent(2, func(e types.T) types.R {
+				if e.(int) == 2 {
+					if name == "Goexit" {
+						runtime.Goexit()
+					}
+					panic(nil)
+				}
+				return e
+			}).ToSlice()
+		}()
+	}
+}
+
+func TestAsyncCancelAfterCompletion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// OnClose 在终止操作结束后才取消 ctx
+	out, errs := stream.Of(1, 2).OnClose(cancel).Async(ctx, 2)
+	var got []types.T
+	for t := range out {
+		got = append(got, t)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("got error %v after the pipeline completed", err)
+	}
+	if !reflect.DeepEqual(got, []types.T{1, 2}) {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+}
@@ -4,13 +4,33 @@ import (
 	"errors"
 	"github.com/rhzx3519/stream/optional"
 	"github.com/rhzx3519/stream/types"
+	"io"
 	"reflect"
+	"sync"
 )
 
 var (
 	// ErrNotSlice a error to panic when call Slice but argument is not slice
 	ErrNotSlice = errors.New("not slice")
 	ErrNotMap   = errors.New("not map")
+	// ErrNotPair a error to panic when a pair operate meets a element which is not types.Pair
+	ErrNotPair = errors.New("not pair")
+	// ErrNotFlattenable a error to panic when Flatten meets a element which is neither []types.T nor Stream
+	ErrNotFlattenable = errors.New("not flattenable")
+	// ErrInfiniteStream a error to panic when a terminal operate which never short-circuits is called on a infinite stream
+	ErrInfiniteStream = errors.New("infinite stream requires a Limit before a buffering terminal")
+	// ErrNotAssignable a error to panic when Cast meets a element which is not assignable to the target type
+	ErrNotAssignable = errors.New("not assignable")
+	// ErrNotPointer a error to panic when Deref meets a element which is not a pointer
+	ErrNotPointer = errors.New("not pointer")
+	// ErrNilPointer a error to panic when Deref meets a nil pointer
+	ErrNilPointer = errors.New("nil pointer")
+	// ErrNegativeSize a error to panic when a offset or limit is negative
+	ErrNegativeSize = errors.New("size must not be negative")
+	// ErrNonPositiveSize a error to panic when a batch or window size is not positive
+	ErrNonPositiveSize = errors.New("size must be positive")
+	// ErrNilPanic a error to re-raise when a function run on another goroutine panics with nil or exits by runtime.Goexit
+	ErrNilPanic = errors.New("panic with nil or goroutine exited")
 )
 
 // Slice 把任意的切片类型转为[]T类型. 可用作 Of() 入参.
@@ -54,6 +74,18 @@ func Of(elements ...types.T) Stream {
 	return newHead(it(elements...))
 }
 
+// Just creates a Stream which has only the given element, e.g. as the result of a FlatMap function
+func Just(element types.T) Stream {
+	return newHead(it(element))
+}
+
+// Empty creates a Stream which has no element
+func Empty() Stream {
+	return newHead(it())
+}
+
+// OfInts creates a Stream over the given ints directly, elements are boxed one by one when iterating,
+// so it's cheaper than Of(Slice(ints)...) for large slices
 func OfInts(elements ...int) Stream {
 	return newHead(&intsIt{
 		base: &base{
@@ -64,6 +96,7 @@ func OfInts(elements ...int) Stream {
 	})
 }
 
+// OfInt64s like OfInts, element type is int64
 func OfInt64s(elements ...int64) Stream {
 	return newHead(&int64sIt{
 		base: &base{
@@ -74,6 +107,7 @@ func OfInt64s(elements ...int64) Stream {
 	})
 }
 
+// OfFloat32s like OfInts, element type is float32
 func OfFloat32s(element ...float32) Stream {
 	return newHead(&float32sIt{
 		base: &base{
@@ -84,6 +118,7 @@ func OfFloat32s(element ...float32) Stream {
 	})
 }
 
+// OfFloat64s like OfInts, element type is float64
 func OfFloat64s(element ...float64) Stream {
 	return newHead(&float64sIt{
 		base: &base{
@@ -94,6 +129,7 @@ func OfFloat64s(element ...float64) Stream {
 	})
 }
 
+// OfStrings like OfInts, element type is string
 func OfStrings(element ...string) Stream {
 	return newHead(&stringIt{
 		base: &base{
@@ -104,13 +140,13 @@ func OfStrings(element ...string) Stream {
 	})
 }
 
-// OfSlice return a Stream. the input parameter `slice` must be a slice.
+// OfSlice return a Stream. the input parameter `slice` must be a slice or array.
 // if input is nil, return a empty Stream( same as Of() )
 func OfSlice(slice types.T) Stream {
 	if optional.IsNil(slice) {
 		return Of()
 	}
-	if reflect.TypeOf(slice).Kind() != reflect.Slice {
+	if kind := reflect.TypeOf(slice).Kind(); kind != reflect.Slice && kind != reflect.Array {
 		panic(ErrNotSlice)
 	}
 	value := reflect.ValueOf(slice)
@@ -124,6 +160,12 @@ func OfSlice(slice types.T) Stream {
 	return newHead(it)
 }
 
+// FromSlice creates a Stream over any slice or array(e.g. []MyStruct) by reflection, same as OfSlice.
+// it panics with ErrNotSlice if the argument is not a slice or array
+func FromSlice(slice interface{}) Stream {
+	return OfSlice(slice)
+}
+
 // OfMap return a Stream which element type is types.Pair.
 // the input parameter `mapValue` must be a map or it will panic
 // if mapValue is nil, return a empty Stream ( same as Of() )
@@ -145,16 +187,48 @@ func OfMap(mapValue types.T) Stream {
 	return newHead(it)
 }
 
+// FromSyncMap return a Stream which element type is types.Pair, like OfMap.
+// entries are collected by m.Range when the terminal operate starts iterating, so it's a snapshot:
+// entries stored or deleted after that are not reflected, and as sync.Map.Range,
+// the snapshot is not necessarily consistent if the map is modified concurrently while collecting.
+// if m is nil, return a empty Stream ( same as Of() )
+func FromSyncMap(m *sync.Map) Stream {
+	if m == nil {
+		return Of()
+	}
+	return newHead(&syncMapIt{m: m})
+}
+
+// FromLines creates a Stream which each element is a line(string, without line terminator) read from `r`.
+// lines are read lazily, so FromLines(r).Limit(n) reads only the first n lines.
+// if reading fails, the terminal operate panics with the error returned by bufio.Scanner,
+// use OnClose to close the reader in either case.
+func FromLines(r io.Reader) Stream {
+	return newHead(withLines(r))
+}
+
 // Iterate create a Stream by a seed and an UnaryOperator
 func Iterate(seed types.T, operator types.UnaryOperator) Stream {
 	return newHead(withSeed(seed, operator))
 }
 
+// IterateN like Iterate, but the Stream has at most `count` elements.
+// the size is known, but Count still calls `operator` for each element
+func IterateN(seed types.T, operator types.UnaryOperator, count int64) Stream {
+	return newHead(withCount(withSeed(seed, operator), count))
+}
+
 // Generate generates a infinite Stream which each element is generate by Supplier
 func Generate(get types.Supplier) Stream {
 	return newHead(withSupplier(get))
 }
 
+// GenerateN like Generate, but the Stream has `count` elements, so downstream operates know the size.
+// Count still calls `get` for each element
+func GenerateN(get types.Supplier, count int64) Stream {
+	return newHead(withCount(withSupplier(get), count))
+}
+
 // Repeat returns a infinite Stream which all element is same
 func Repeat(e types.T) Stream {
 	return newHead(withSupplier(func() types.R {
@@ -164,7 +238,50 @@ func Repeat(e types.T) Stream {
 
 // RepeatN returns a Stream which has `count` element and all the element is the given `e`
 func RepeatN(e types.T, count int64) Stream {
-	return Repeat(e).Limit(count)
+	return newHead(withCount(withCycle([]types.T{e}, -1), count))
+}
+
+// Generator creates a Stream from a yield-style producer: `produce` pushes elements by calling `emit`,
+// e.g. a recursive tree walk or a callback based API. since the pipeline pulls elements, `produce` runs
+// in a new goroutine started lazily by the first pull, and each `emit` blocks until the element is pulled.
+// when the stream is closed(the terminal operate returns, including short-circuit and panic), a pending or later
+// `emit` exits the goroutine by runtime.Goexit, so deferred calls in `produce` still run but code after `emit` doesn't.
+// `emit` must be called only from the goroutine running `produce` and before it returns, and `produce` blocking
+// on other things than `emit` leaks the goroutine. a panic in `produce` is re-raised in the terminal operate.
+func Generator(produce func(emit types.Consumer)) Stream {
+	g := withGenerator(produce)
+	head := newHead(g)
+	head.onClose = g.stop
+	return head
+}
+
+// ConcatAll creates a Stream which has all elements of the first stream, then the second one, and so on.
+// a stream is not touched until the previous ones are exhausted, so a short-circuit terminal skips the rest.
+// the size is the sum of the sizes if all are known. closing the returned Stream closes all the streams.
+func ConcatAll(streams ...Stream) Stream {
+	its, closeAll := iteratorsOf(streams)
+	head := newHead(withConcat(its))
+	head.onClose = closeAll
+	return head
+}
+
+// MergeByKey merges streams which are each sorted by `keyCmp` on the key extracted by `keyFn` into one sorted Stream,
+// elements with equal keys are emitted in the order of their streams in the argument. it keeps only the current
+// element of each stream in a min-heap, so streams are pulled one element at a time and never buffered.
+// the streams are pulled only when the terminal operate starts, and all are closed when the returned Stream is closed.
+// the size is the sum of the sizes if all are known, but Count still merges the streams and calls `keyFn` and `keyCmp`.
+// the result is not sorted if any of the streams isn't.
+func MergeByKey(streams []Stream, keyFn types.Function, keyCmp types.Comparator) Stream {
+	its, closeAll := iteratorsOf(streams)
+	head := newHead(withMerge(its, keyFn, keyCmp))
+	head.onClose = closeAll
+	return head
+}
+
+// Cycle returns a Stream which iterates `elements` repeatedly for `times` times, times < 0 means infinite.
+// an empty `elements` yields nothing even if times < 0
+func Cycle(elements []types.T, times int) Stream {
+	return newHead(withCycle(elements, times))
 }
 
 // IntRange creates a Stream which element is the given range
@@ -192,3 +309,31 @@ func Int64RangeStep(fromInclude, toExclude int64, step int) Stream {
 		return int64(t.(epInt64))
 	})
 }
+
+// Equal 同时遍历两个流, 长度相同并且对应的元素都满足 eq 时返回 true, 遇到第一个不相等的元素就提前结束
+// Equal traverses both streams in lockstep, returns true only if they have the same length
+// and each corresponding pair satisfies `eq`. it short-circuits on the first mismatch, both streams are closed at return.
+func Equal(a, b Stream, eq func(x, y types.T) bool) bool {
+	ia, ib := withPull(a.(*stream)), withPull(b.(*stream))
+	defer ib.close()
+	defer ia.close()
+	for {
+		hasNext := ia.HasNext()
+		if hasNext != ib.HasNext() {
+			return false
+		}
+		if !hasNext {
+			return true
+		}
+		if !eq(ia.Next(), ib.Next()) {
+			return false
+		}
+	}
+}
+
+// RangeRune creates a Stream which element is each rune in [from, to)
+func RangeRune(fromInclude, toExclude rune) Stream {
+	return newHead(withRange(epRune(fromInclude), epRune(toExclude), 1)).Map(func(t types.T) types.R {
+		return rune(t.(epRune))
+	})
+}
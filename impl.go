@@ -1,10 +1,19 @@
 package stream
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/rhzx3519/stream/optional"
 	"github.com/rhzx3519/stream/types"
+	"io"
+	"math"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // stream is a node show as below. which source is a iterator. head stream has no prev node.
@@ -31,9 +40,14 @@ import (
 //
 //               <----- wrapped stage ----->
 type stream struct {
-	source iterator
-	prev   *stream
-	wrap   func(stage) stage
+	source  iterator
+	prev    *stream
+	wrap    func(stage) stage
+	onClose  func() // 关闭回调, 见 OnClose
+	name     string // 操作名称, 见 Explain
+	keepSize bool   // 该操作不改变元素个数, 且不调用用户函数, 见 Count
+	bounded  bool   // 该操作可以让无限流结束, 如 Limit
+	buffered bool   // 该操作会缓存所有元素直到 End, 如 Sorted
 }
 
 // region help methods
@@ -43,12 +57,13 @@ func newHead(source iterator) *stream {
 	return &stream{source: source}
 }
 
-// 构造中间节点
-func newNode(prev *stream, wrap func(stage) stage) *stream {
+// 构造中间节点, name 是操作的描述, 见 Explain
+func newNode(prev *stream, name string, wrap func(stage) stage) *stream {
 	return &stream{
 		source: prev.source,
 		prev: prev,
 		wrap: wrap,
+		name: name,
 	}
 }
 
@@ -57,10 +72,14 @@ func newNode(prev *stream, wrap func(stage) stage) *stream {
 // 2. 打包所有流操作
 // 3. 依次遍历所有元素
 func (s *stream) terminal(ts *terminalStage) {
+	defer s.close()
+	if s.infinite(ts.canFinish != nil) {
+		panic(ErrInfiniteStream)
+	}
 	stage := s.wrapStage(ts) // 返回的stage是一个操作集合，即 stage1->stage2->...stage n
 	source := s.source
 	stage.Begin(source.GetSizeIfKnown())
-	for source.HasNext() && !stage.CanFinish() {
+	for !stage.CanFinish() && source.HasNext() { // 先判断是否可以提前结束, 避免多读一个元素
 		stage.Accept(source.Next())
 	}
 	stage.End()
@@ -75,168 +94,1393 @@ func (s *stream) wrapStage(terminalStage stage) stage {
 	return stage
 }
 
+// 从头节点开始, 按注册顺序依次调用所有关闭回调
+func (s *stream) close() {
+	var handlers []func()
+	for i := s; i != nil; i = i.prev {
+		if i.onClose != nil {
+			handlers = append(handlers, i.onClose)
+		}
+	}
+	for i := len(handlers) - 1; i >= 0; i-- {
+		handlers[i]()
+	}
+}
+
+// 标记该节点不改变元素个数
+func (s *stream) keepingSize() *stream {
+	s.keepSize = true
+	return s
+}
+
+// 标记该节点可以让无限流结束
+func (s *stream) bounding() *stream {
+	s.bounded = true
+	return s
+}
+
+// 标记该节点会缓存所有元素
+func (s *stream) buffering() *stream {
+	s.buffered = true
+	return s
+}
+
+// 数据源是无限的, 并且没有操作能让流结束时返回 true. shortCircuit 表示终止操作是否可以提前结束
+// 离数据源最近的 bounded 或 buffered 节点决定流能否结束: 在 buffered 节点之后的 Limit 无法让数据源停止
+func (s *stream) infinite(shortCircuit bool) bool {
+	if !s.source.IsInfinite() {
+		return false
+	}
+	for i := s; i.prev != nil; i = i.prev {
+		if i.bounded {
+			shortCircuit = true
+		}
+		if i.buffered {
+			shortCircuit = false
+		}
+	}
+	return !shortCircuit
+}
+
+// 如果所有操作和数据源都不改变元素个数且不调用用户函数, 返回数据源的元素个数, 否则返回 unkonwnSize
+func (s *stream) passThroughSize() int64 {
+	for i := s; i.prev != nil; i = i.prev {
+		if !i.keepSize {
+			return unkonwnSize
+		}
+	}
+	if callsUserFunc(s.source) {
+		return unkonwnSize
+	}
+	return s.source.GetSizeIfKnown()
+}
+
+// 遍历该数据源是否会调用用户函数, 如 GenerateN 的 supplier, IterateN 的 operator, MergeByKey 的 keyFn
+func callsUserFunc(source iterator) bool {
+	switch it := source.(type) {
+	case *supplierIt, *seedIt, *generatorIt, *mergeIt:
+		return true
+	case *countIt:
+		return callsUserFunc(it.source)
+	case *concatIt:
+		for _, i := range it.its[it.index:] {
+			if callsUserFunc(i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Explain 按顺序描述流中的所有操作, 如 "Filter -> Map -> Limit(10)"
+// Explain returns a human-readable description of the operations from head to this node
+func (s *stream) Explain() string {
+	var names []string
+	for i := s; i.prev != nil; i = i.prev {
+		names = append(names, i.name)
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, " -> ")
+}
+
+// 把元素转为 types.Pair, 不是 types.Pair 时 panic
+func asPair(t types.T) types.Pair {
+	pair, ok := t.(types.Pair)
+	if !ok {
+		panic(ErrNotPair)
+	}
+	return pair
+}
+
+// 返回元素的 reflect.Value, 元素为 nil 时返回 typ 类型的零值
+func valueOf(e types.T, typ reflect.Type) reflect.Value {
+	if e == nil {
+		return reflect.Zero(typ)
+	}
+	return reflect.ValueOf(e)
+}
+
+// Limit 之后的 size: 限制在 [0, maxSize], 未知大小保持不变
+func limitSize(size, maxSize int64) int64 {
+	if maxSize < 0 {
+		maxSize = 0
+	}
+	if size > maxSize {
+		return maxSize
+	}
+	return size
+}
+
+// Skip 之后的 size: 限制在 [0, size], n 不能为负数, 未知大小保持不变
+func skipSize(size, n int64) int64 {
+	if size < 0 {
+		return size
+	}
+	if n >= size {
+		return 0
+	}
+	return size - n
+}
+
+// 根据 size 计算预分配的容量, 限制在 [0, maxPreallocSize]
+// size 来自元素个数的计算或者调用方传入的参数(如 Head, Buffer 的 n), 都必须经过它再预分配
+func capacityOf(sizeMayNegative int64) int {
+	if sizeMayNegative < 0 {
+		return 0
+	}
+	if sizeMayNegative > maxPreallocSize {
+		return maxPreallocSize
+	}
+	return int(sizeMayNegative)
+}
+
+// 返回遍历流的迭代器: 只有数据源的流直接使用数据源, 否则转为拉取方式的迭代器(需要关闭)
+func iteratorOf(s Stream) iterator {
+	ss := s.(*stream)
+	if ss.prev == nil && ss.onClose == nil {
+		return ss.source
+	}
+	return withPull(ss)
+}
+
+// 返回遍历每个流的迭代器, 以及关闭其中所有拉取方式的迭代器的函数
+func iteratorsOf(streams []Stream) ([]iterator, func()) {
+	its := make([]iterator, len(streams))
+	var pulls []*pullIt
+	for i, s := range streams {
+		its[i] = iteratorOf(s)
+		if p, ok := its[i].(*pullIt); ok {
+			pulls = append(pulls, p)
+		}
+	}
+	return its, func() {
+		for _, p := range pulls {
+			p.close()
+		}
+	}
+}
+
 // end region help methods
 
 // region stateless operate 无状态操作
 
 // 过滤操作, down stage 指代下一个操作
 func (s *stream) Filter(test types.Predicate) Stream {
-	return newNode(s, func(down stage) stage {
+	return newNode(s, "Filter", func(down stage) stage {
+		return newChainedStage(down, begin(func(int64) {
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			if test(t) {
+				down.Accept(t)
+			}
+		}))
+	})
+}
+
+// FilterCounting 同 Filter, 同时把不满足条件的元素个数累加到 *rejected
+// FilterCounting keeps elements which satisfy `test` like Filter, and adds 1 to `*rejected` for each rejected element.
+// the counter is updated by atomic.AddInt64, so it can be read by atomic.LoadInt64 while the pipeline is running.
+func (s *stream) FilterCounting(test types.Predicate, rejected *int64) Stream {
+	return newNode(s, "FilterCounting", func(down stage) stage {
 		return newChainedStage(down, begin(func(int64) {
 			down.Begin(unkonwnSize)
 		}), action(func(t types.T) {
 			if test(t) {
 				down.Accept(t)
+			} else {
+				atomic.AddInt64(rejected, 1)
 			}
 		}))
 	})
 }
 
+// FilterIndexed 带下标的过滤操作
+// FilterIndexed keeps elements which satisfy `test`. index is the 0-based position of the element
+// as it arrives at this stage(after upstream operates), it increases on every element whether passed or not.
+func (s *stream) FilterIndexed(test func(index int64, t types.T) bool) Stream {
+	return newNode(s, "FilterIndexed", func(down stage) stage {
+		index := int64(0)
+		return newChainedStage(down, begin(func(int64) {
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			if test(index, t) {
+				down.Accept(t)
+			}
+			index++
+		}))
+	})
+}
 
 // Map 转换操作
 // apply is a Function, convert the element to another 转换元素
 func (s *stream) Map(apply types.Function) Stream {
-	return newNode(s, func(down stage) stage {
+	return newNode(s, "Map", func(down stage) stage {
 		return newChainedStage(down, action(func(t types.T) {
 			down.Accept(apply(t))
 		}))
 	})
 }
 
+// MapAll 在同一个操作中依次使用 funcs 转换元素, 等同于 Map(f1).Map(f2)..., 但是只有一个 stage
+// MapAll applies `funcs` to each element from left to right, i.e. fn(...f2(f1(t))), in a single stage,
+// which is handy when the transformations are built dynamically. no function means the identity.
+func (s *stream) MapAll(funcs ...types.Function) Stream {
+	return newNode(s, fmt.Sprintf("MapAll(%d)", len(funcs)), func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			for _, apply := range funcs {
+				t = apply(t)
+			}
+			down.Accept(t)
+		}))
+	})
+}
+
+// MapWith 以每个元素为第一个参数, 固定的 u 为第二个参数调用 apply, 如 s.MapWith(scale, multiply)
+// MapWith applies `apply` with each element as the first argument and the fixed `u` as the second
+func (s *stream) MapWith(u types.U, apply types.BiFunction) Stream {
+	return newNode(s, "MapWith", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(apply(t, u))
+		}))
+	})
+}
+
+// MapInPlace 原地转换元素, operator 可以修改并返回同一个元素, 避免额外的分配
+// MapInPlace is wired like Map, but the UnaryOperator signals that the result has the same type as the element,
+// and `operator` may mutate its argument(e.g. a pointer or a slice) and return it, so no extra allocation is needed.
+// it's unsafe if the elements are shared or retained elsewhere(e.g. the slice passed to Of), they're changed as well.
+func (s *stream) MapInPlace(operator types.UnaryOperator) Stream {
+	return newNode(s, "MapInPlace", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(operator(t))
+		}))
+	})
+}
+
+// TryMap 转换元素, 结果包装为 optional.Optional 发送给下游: 成功时有值, apply panic 时为空
+// TryMap applies `apply` to each element and emits an optional.Optional: present with the result on success,
+// empty if `apply` panics(a nil result is also empty, like optional.OfNullable).
+// only panics from `apply` itself are recovered, panics in downstream operates are not.
+// chain MapOptional(func(t types.T) optional.Optional { return t.(optional.Optional) }) to keep successful results only.
+func (s *stream) TryMap(apply types.Function) Stream {
+	try := func(t types.T) (result optional.Optional) {
+		defer func() {
+			if result == nil { // apply panic 了
+				recover()
+				result = optional.Empty()
+			}
+		}()
+		return optional.OfNullable(apply(t))
+	}
+	return newNode(s, "TryMap", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(try(t))
+		}))
+	})
+}
+
+// Deref 把指针元素转为指向的值, 如 FromSlice([]*T) 之后得到 T 的流
+// Deref emits the value each pointer element points to via reflection. it panics with a error wrapping
+// ErrNilPointer on a nil pointer(or nil element), and ErrNotPointer on a element which is not a pointer.
+func (s *stream) Deref() Stream {
+	return s.deref("Deref", func(t types.T) types.T {
+		panic(fmt.Errorf("%w: %T", ErrNilPointer, t))
+	})
+}
+
+// DerefOr 同 Deref, 但是 nil 指针转为 defaultVal
+// DerefOr is Deref which emits `defaultVal` instead of panicking for a nil pointer(or nil element)
+func (s *stream) DerefOr(defaultVal types.T) Stream {
+	return s.deref("DerefOr", func(types.T) types.T {
+		return defaultVal
+	})
+}
+
+// onNil 返回 nil 指针对应的元素
+func (s *stream) deref(name string, onNil func(t types.T) types.T) Stream {
+	return newNode(s, name, func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			if t == nil {
+				down.Accept(onNil(t))
+				return
+			}
+			v := reflect.ValueOf(t)
+			if v.Kind() != reflect.Ptr {
+				panic(fmt.Errorf("%w: %T", ErrNotPointer, t))
+			}
+			if v.IsNil() {
+				down.Accept(onNil(t))
+				return
+			}
+			down.Accept(v.Elem().Interface())
+		}))
+	})
+}
+
+// Cast 断言每个元素都可以赋值给 typ, 原样发送给下游
+// Cast asserts each element is assignable to `typ` and passes it through,
+// panic with a error wrapping ErrNotAssignable, which contains the actual type, on mismatch.
+// nil is accepted if `typ` is a interface, pointer, slice, map, chan or func type.
+func (s *stream) Cast(typ reflect.Type) Stream {
+	return newNode(s, fmt.Sprintf("Cast(%v)", typ), func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			if !assignable(t, typ) {
+				panic(fmt.Errorf("%w: %T to %v", ErrNotAssignable, t, typ))
+			}
+			down.Accept(t)
+		}))
+	})
+}
+
+func assignable(t types.T, typ reflect.Type) bool {
+	if t == nil {
+		switch typ.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func:
+			return true
+		}
+		return false
+	}
+	return reflect.TypeOf(t).AssignableTo(typ)
+}
+
+
+// MapOptional 转换元素, 只把有值的结果发送给下游
+// MapOptional applies `apply` to each element, and only the value of present results are forwarded downstream
+func (s *stream) MapOptional(apply func(t types.T) optional.Optional) Stream {
+	return newNode(s, "MapOptional", func(down stage) stage {
+		return newChainedStage(down, begin(func(int64) {
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			apply(t).IfPresent(down.Accept)
+		}))
+	})
+}
+
+// MapConcurrent 使用最多 parallelism 个 goroutine 并发转换元素, 结果仍按原顺序发送给下游
+// MapConcurrent applies `apply` on up to `parallelism` goroutines, results are emitted downstream in source order:
+// once `parallelism` elements are in flight, it waits for the oldest one before starting a new one.
+// when downstream can finish(e.g. Limit is satisfied), no more work is scheduled and pending results are discarded.
+// if `apply` panics, the panic is re-raised on the caller goroutine when its result is due,
+// panic(nil) or runtime.Goexit in `apply` is re-raised as ErrNilPanic.
+// all started goroutines are waited before the downstream End.
+func (s *stream) MapConcurrent(parallelism int, apply types.Function) Stream {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	type result struct {
+		value     types.R
+		recovered interface{}
+	}
+	return newNode(s, fmt.Sprintf("MapConcurrent(%d)", parallelism), func(down stage) stage {
+		var pending []chan result // 按元素顺序排列的结果, 用于重新排序
+		emitOldest := func() {
+			r := <-pending[0]
+			pending = pending[1:]
+			if down.CanFinish() {
+				return
+			}
+			if r.recovered != nil {
+				panic(r.recovered)
+			}
+			down.Accept(r.value)
+		}
+		return newChainedStage(down, action(func(t types.T) {
+			if down.CanFinish() {
+				return
+			}
+			if len(pending) >= parallelism {
+				emitOldest()
+			}
+			ch := make(chan result, 1)
+			pending = append(pending, ch)
+			go func() {
+				done := false
+				defer func() {
+					if done {
+						return
+					}
+					// 一定要发送结果, 否则 emitOldest 会一直阻塞; panic(nil) 时 recover 返回 nil
+					r := recover()
+					if r == nil {
+						r = ErrNilPanic
+					}
+					ch <- result{recovered: r}
+				}()
+				value := apply(t)
+				done = true
+				ch <- result{value: value}
+			}()
+		}), end(func() {
+			for len(pending) > 0 {
+				emitOldest()
+			}
+			down.End()
+		}))
+	})
+}
 
 // FlatMap 打平集合为元素。[[1,2],[3,4]] -> [1,2,3,4]
+// the size reported downstream is always unknown: Begin is called before any element is flattened,
+// so the sizes of the sub-streams can't be summed lazily. use FlatMapSized if the total size can be estimated.
+// a sub-stream stops as soon as the downstream can finish(e.g. a Limit is satisfied), so it may be infinite.
 func (s *stream) FlatMap(flatten func(types.T) Stream) Stream {
-	return newNode(s, func(down stage) stage {
+	return s.flatMap("FlatMap", unkonwnSize, flatten)
+}
+
+// FlatMapSized like FlatMap, but reports `sizeHint` as the size downstream, so that Sorted/ToSlice etc. can preallocate.
+// `sizeHint` is only an estimate, the actual count of elements may differ.
+func (s *stream) FlatMapSized(sizeHint int64, flatten func(types.T) Stream) Stream {
+	if sizeHint < 0 {
+		sizeHint = unkonwnSize
+	}
+	return s.flatMap(fmt.Sprintf("FlatMapSized(%d)", sizeHint), sizeHint, flatten)
+}
+
+// Flatten concatenates elements which are []types.T or Stream, panic with ErrNotFlattenable for other elements
+// 打平元素类型为 []types.T 或 Stream 的流
+func (s *stream) Flatten() Stream {
+	return s.flatMap("Flatten", unkonwnSize, func(t types.T) Stream {
+		switch e := t.(type) {
+		case []types.T:
+			return Of(e...)
+		case Stream:
+			return e
+		default:
+			panic(ErrNotFlattenable)
+		}
+	})
+}
+
+func (s *stream) flatMap(name string, size int64, flatten func(types.T) Stream) Stream {
+	return newNode(s, name, func(down stage) stage {
 		return newChainedStage(down, begin(func(int64) {
-				down.Begin(unkonwnSize)
+				down.Begin(size)
 			}), action(func(t types.T) {
 				ss := flatten(t)		// 元素是集合, 转化为流
-				ss.ForEach(down.Accept) // 依次消费流中的数据
+				// 依次消费流中的数据, 下游可以结束时(如 Limit 已满)不再继续消费
+				if sub, ok := ss.(*stream); ok {
+					sub.terminal(newTerminalStage(down.Accept, canFinish(down.CanFinish)))
+				} else {
+					ss.ForEach(down.Accept)
+				}
 		}))
 	})
 }
 
 // Peek visit every element and leave them on stream so that they can be operated by next action  访问流中每个元素而不消费它，可用于 debug
+// `consumer` is called in the order elements arrive at this stage, e.g. in sorted order after Sorted, see Stream.
 func (s *stream) Peek(consumer types.Consumer) Stream {
-	return newNode(s, func(down stage) stage {
+	return newNode(s, "Peek", func(down stage) stage {
 		return newChainedStage(down, action(func(t types.T) {
 			consumer(t)
 			down.Accept(t)
 		}))
-	})
+	})
+}
+
+// PeekLog 用 logf 记录每个元素, 如测试中的 t.Logf
+// PeekLog logs each element passing through by logf("%s%v", prefix, t), e.g. with `t.Logf` of testing.TB
+// to debug a failing pipeline test. a nil `logf` makes it a pure pass-through without formatting.
+func (s *stream) PeekLog(logf func(format string, args ...interface{}), prefix string) Stream {
+	return newNode(s, "PeekLog", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			if logf != nil {
+				logf("%s%v", prefix, t)
+			}
+			down.Accept(t)
+		}))
+	})
+}
+
+// Collecting 把经过的每个元素追加到 *dst, 同时原样发送给下游, 可用于调试时查看中间结果
+// Collecting appends each element passing through to `*dst` and forwards it downstream, it's
+// Peek(func(t types.T) { *dst = append(*dst, t) }). the append is not synchronized, so `*dst` must not
+// be read or written by other goroutines until the terminal operate returns.
+func (s *stream) Collecting(dst *[]types.T) Stream {
+	return newNode(s, "Collecting", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			*dst = append(*dst, t)
+			down.Accept(t)
+		}))
+	})
+}
+
+// WithProgress 每经过 every 个元素, 调用 report 报告进度
+// WithProgress calls `report` every `every` elements with the count of elements passed so far
+// and the total size from the begin size hint(-1 if unknown). every < 1 is treated as 1.
+func (s *stream) WithProgress(every int64, report func(done int64, total int64)) Stream {
+	if every < 1 {
+		every = 1
+	}
+	return newNode(s, fmt.Sprintf("WithProgress(%d)", every), func(down stage) stage {
+		var done, total int64
+		return newChainedStage(down, begin(func(size int64) {
+			done, total = 0, size
+			down.Begin(size)
+		}), action(func(t types.T) {
+			done++
+			if done%every == 0 {
+				report(done, total)
+			}
+			down.Accept(t)
+		}))
+	})
+}
+
+// PeekProgress 对每个元素调用 consumer, 同时给出下标, 总数和已处理的个数
+// PeekProgress calls `consumer` for each element passing through with its 0-based `index`,
+// the `total` from the begin size hint(-1 if unknown), and the running `done` count including this element,
+// that is index + 1. it combines the index of FilterIndexed with the counters of WithProgress for logging.
+func (s *stream) PeekProgress(consumer func(index, total, done int64, t types.T)) Stream {
+	return newNode(s, "PeekProgress", func(down stage) stage {
+		var index, total int64
+		return newChainedStage(down, begin(func(size int64) {
+			index, total = 0, size
+			down.Begin(size)
+		}), action(func(t types.T) {
+			consumer(index, total, index+1, t)
+			index++
+			down.Accept(t)
+		}))
+	})
+}
+
+// PeekLifecycle 在 begin, 每个元素, end 时分别调用对应的回调, 可用于监控流的某一段
+// PeekLifecycle calls `onBegin` with the size(or -1 if unknown) before elements arrive, `onEach` for every element,
+// and `onEnd` after all elements passed through this stage(before the downstream End).
+// any of the callbacks can be nil, then the corresponding phase is a pure pass-through.
+func (s *stream) PeekLifecycle(onBegin func(size int64), onEach types.Consumer, onEnd func()) Stream {
+	return s.lifecycle("PeekLifecycle", onBegin, onEach, onEnd)
+}
+
+// OnStart 只在 begin 时调用 fn, 元素直接透传, 没有逐个元素的开销
+// OnStart calls `fn` with the size hint(or -1 if unknown) when this stage begins, elements pass through untouched.
+func (s *stream) OnStart(fn func(sizeHint int64)) Stream {
+	return s.lifecycle("OnStart", fn, nil, nil)
+}
+
+// OnComplete 只在 end 时调用 fn, 元素直接透传, 没有逐个元素的开销
+// OnComplete calls `fn` after all elements passed through this stage(before the downstream End).
+// it's not called if the pipeline panics, use OnClose for cleanup that must always run.
+func (s *stream) OnComplete(fn func()) Stream {
+	return s.lifecycle("OnComplete", nil, nil, fn)
+}
+
+func (s *stream) lifecycle(name string, onBegin func(size int64), onEach types.Consumer, onEnd func()) Stream {
+	return newNode(s, name, func(down stage) stage {
+		var opts []option
+		if onBegin != nil {
+			opts = append(opts, begin(func(size int64) {
+				onBegin(size)
+				down.Begin(size)
+			}))
+		}
+		if onEach != nil {
+			opts = append(opts, action(func(t types.T) {
+				onEach(t)
+				down.Accept(t)
+			}))
+		}
+		if onEnd != nil {
+			opts = append(opts, end(func() {
+				onEnd()
+				down.End()
+			}))
+		}
+		return newChainedStage(down, opts...)
+	})
+}
+
+// MapToPair 把每个元素转为 types.Pair{First: keyFn(t), Second: valueFn(t)}
+// MapToPair converts each element to a types.Pair, so that pair operates(Keys, Values...) can be chained
+func (s *stream) MapToPair(keyFn, valueFn types.Function) Stream {
+	return newNode(s, "MapToPair", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(types.Pair{
+				First:  keyFn(t),
+				Second: valueFn(t),
+			})
+		}))
+	})
+}
+
+// Enumerate 把每个元素 e 转为 types.Pair{First: 下标, Second: e}, 同 Python 的 enumerate
+// Enumerate converts each element to a types.Pair whose First is the int64 0-based index of the element
+// as it arrives at this stage(after upstream operates), and Second is the element itself.
+func (s *stream) Enumerate() Stream {
+	return newNode(s, "Enumerate", func(down stage) stage {
+		index := int64(0)
+		return newChainedStage(down, begin(func(size int64) {
+			index = 0
+			down.Begin(size)
+		}), action(func(t types.T) {
+			down.Accept(types.Pair{
+				First:  index,
+				Second: t,
+			})
+			index++
+		}))
+	}).keepingSize()
+}
+
+// MapKeys 转换 types.Pair 元素的 First
+// MapKeys applies `apply` to the First of each types.Pair element, panic if a element is not types.Pair
+func (s *stream) MapKeys(apply types.Function) Stream {
+	return newNode(s, "MapKeys", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			pair := asPair(t)
+			pair.First = apply(pair.First)
+			down.Accept(pair)
+		}))
+	})
+}
+
+// MapValues 转换 types.Pair 元素的 Second
+// MapValues applies `apply` to the Second of each types.Pair element, panic if a element is not types.Pair
+func (s *stream) MapValues(apply types.Function) Stream {
+	return newNode(s, "MapValues", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			pair := asPair(t)
+			pair.Second = apply(pair.Second)
+			down.Accept(pair)
+		}))
+	})
+}
+
+// MapPair 同时转换 types.Pair 元素的 First 和 Second
+// MapPair applies `keyMapper` to the First and `valueMapper` to the Second of each types.Pair element in one stage,
+// a nil mapper keeps that side unchanged. panic if a element is not types.Pair
+func (s *stream) MapPair(keyMapper, valueMapper types.Function) Stream {
+	return newNode(s, "MapPair", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			pair := asPair(t)
+			if keyMapper != nil {
+				pair.First = keyMapper(pair.First)
+			}
+			if valueMapper != nil {
+				pair.Second = valueMapper(pair.Second)
+			}
+			down.Accept(pair)
+		}))
+	})
+}
+
+// Keys 取出 types.Pair 元素的 First
+// Keys projects each types.Pair element to its First, panic if a element is not types.Pair
+func (s *stream) Keys() Stream {
+	return newNode(s, "Keys", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(asPair(t).First)
+		}))
+	}).keepingSize()
+}
+
+// Values 取出 types.Pair 元素的 Second
+// Values projects each types.Pair element to its Second, panic if a element is not types.Pair
+func (s *stream) Values() Stream {
+	return newNode(s, "Values", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			down.Accept(asPair(t).Second)
+		}))
+	}).keepingSize()
+}
+
+// Recover 捕获下游处理某个元素时的 panic, 交给 handler 处理, handler 的返回值代替原元素发送给下游
+// Recover wraps the downstream Accept in a deferred recover. when a downstream stage panics on an element,
+// `handler` receives the panic value and the element, its result is emitted downstream instead(return nil to skip the element).
+// Note: it only catches panics raised by downstream stages when accepting a element,
+// panics from the source, upstream stages, or the downstream Begin/End(e.g. comparator of Sorted) are not caught,
+// nor is a panic raised by accepting the replacement. side effects the downstream already did on the bad element are not undone.
+func (s *stream) Recover(handler func(recovered interface{}, element types.T) types.T) Stream {
+	return newNode(s, "Recover", func(down stage) stage {
+		return newChainedStage(down, action(func(t types.T) {
+			replacement, panicked := func() (result types.T, panicked bool) {
+				defer func() {
+					if r := recover(); r != nil {
+						result, panicked = handler(r, t), true
+					}
+				}()
+				down.Accept(t)
+				return nil, false
+			}()
+			if panicked && replacement != nil {
+				down.Accept(replacement)
+			}
+		}))
+	})
+}
+
+// OnClose registers a cleanup callback which runs after the terminal operate finished(including short-circuit and panic).
+// callbacks accumulate across the pipeline and fire in registration order, each at most once.
+// 注册关闭回调, 终止操作结束后(包括提前结束和 panic)按注册顺序调用, 每个回调至多调用一次
+func (s *stream) OnClose(fn func()) Stream {
+	var once sync.Once
+	node := newNode(s, "OnClose", func(down stage) stage {
+		return down
+	})
+	node.onClose = func() {
+		once.Do(fn)
+	}
+	return node.keepingSize()
+}
+
+// end region stateless operate
+
+// region stateful operate 有状态操作
+
+// Distinct remove duplicate 去重操作
+// distincter is a IntFunction, which return a int hashcode to identity each element 返回元素的唯一标识用于区分每个元素
+func (s *stream) Distinct(distincter types.IntFunction) Stream {
+	return newNode(s, "Distinct", func(down stage) stage {
+		var set map[int]bool
+		return newChainedStage(down, begin(func(size int64) {
+			set = make(map[int]bool, capacityOf(size)) // 已知元素个数时预分配, 避免 map 反复扩容
+			down.Begin(unkonwnSize)		// 去重后的个数不确定
+		}), action(func(t types.T) {
+			hash := distincter(t)
+			if _, ok := set[hash]; !ok { // 唯一的元素才往下游发送
+				set[hash] = true
+				down.Accept(t)
+			}
+		}), end(func() {
+			set = nil
+			down.End()
+		}))
+	})
+}
+
+// Duplicates 和 Distinct 相反, 只发送标识已经出现过的元素, 第一次出现的元素被丢弃
+// Duplicates emits only elements whose key has been seen before, the complement of Distinct.
+// if `everyRepeat` is false an element is emitted only the second time its key appears,
+// otherwise every repeated occurrence(the second, third...) is emitted.
+func (s *stream) Duplicates(distincter types.IntFunction, everyRepeat bool) Stream {
+	return newNode(s, "Duplicates", func(down stage) stage {
+		var seen map[int]int // 标识 -> 出现的次数
+		return newChainedStage(down, begin(func(size int64) {
+			seen = make(map[int]int, capacityOf(size))
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			hash := distincter(t)
+			seen[hash]++
+			if n := seen[hash]; n == 2 || (n > 2 && everyRepeat) {
+				down.Accept(t)
+			}
+		}), end(func() {
+			seen = nil
+			down.End()
+		}))
+	})
+}
+
+// DistinctLast 去重操作, 保留每个标识最后出现的元素, 按最后出现的顺序发送
+// DistinctLast keeps the last occurrence of each key, and emits them at end in the order of their last appearance.
+// unlike the streaming Distinct, it buffers all elements until the end.
+func (s *stream) DistinctLast(distincter types.IntFunction) Stream {
+	return newNode(s, "DistinctLast", func(down stage) stage {
+		var list []types.T
+		var hashes []int
+		var last map[int]int // 标识 -> 最后出现的位置
+		return newChainedStage(down, begin(func(int64) {
+			list, hashes = nil, nil
+			last = make(map[int]int)
+		}), action(func(t types.T) {
+			hash := distincter(t)
+			last[hash] = len(list)
+			list = append(list, t)
+			hashes = append(hashes, hash)
+		}), end(func() {
+			down.Begin(int64(len(last)))
+			for i, t := range list {
+				if down.CanFinish() {
+					break
+				}
+				if last[hashes[i]] == i {
+					down.Accept(t)
+				}
+			}
+			list, hashes, last = nil, nil, nil
+			down.End()
+		}))
+	}).buffering()
+}
+
+// DistinctPolicy decides what DistinctBounded does when its seen-set is full
+type DistinctPolicy int
+
+const (
+	// DistinctPassThrough passes unseen elements through without remembering them once the seen-set is full,
+	// so later duplicates of those elements are emitted too
+	DistinctPassThrough DistinctPolicy = iota
+	// DistinctEvictLRU evicts the least recently seen key to remember a new one once the seen-set is full,
+	// so a duplicate whose key has been evicted is emitted again
+	DistinctEvictLRU
+)
+
+// DistinctBounded 去重操作, 但最多记住 maxKeys 个元素的标识, 以内存上限换取去重的准确性
+// DistinctBounded like Distinct, but the seen-set holds at most `maxKeys` keys, what happens when it's full
+// depends on `policy`. duplicates may be emitted in either policy, so it trades exactness for bounded memory,
+// which works well on near-sorted or mostly-unique data. maxKeys < 1 remembers nothing, that is pass-through.
+func (s *stream) DistinctBounded(distincter types.IntFunction, maxKeys int, policy DistinctPolicy) Stream {
+	return newNode(s, fmt.Sprintf("DistinctBounded(%d)", maxKeys), func(down stage) stage {
+		var seen map[int]*list.Element
+		var recent *list.List // 最近出现的 key 在前
+		return newChainedStage(down, begin(func(int64) {
+			seen = make(map[int]*list.Element)
+			recent = list.New()
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			hash := distincter(t)
+			if e, ok := seen[hash]; ok {
+				recent.MoveToFront(e)
+				return
+			}
+			if len(seen) >= maxKeys && policy == DistinctEvictLRU && maxKeys > 0 {
+				delete(seen, recent.Remove(recent.Back()).(int))
+			}
+			if len(seen) < maxKeys {
+				seen[hash] = recent.PushFront(hash)
+			}
+			down.Accept(t)
+		}), end(func() {
+			seen = nil
+			recent = nil
+			down.End()
+		}))
+	})
+}
+
+// DistinctByHashEquals 先按 hash 分桶, 再在桶内用 equals 判断是否重复, 避免 hash 冲突导致误删元素
+// DistinctByHashEquals buckets elements by `hash`, and an element is a duplicate only if `equals` returns true
+// for an element already seen in the same bucket. the first seen element is kept and order is preserved.
+func (s *stream) DistinctByHashEquals(hash types.IntFunction, equals func(a, b types.T) bool) Stream {
+	return newNode(s, "DistinctByHashEquals", func(down stage) stage {
+		var buckets map[int][]types.T
+		return newChainedStage(down, begin(func(int64) {
+			buckets = make(map[int][]types.T)
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			h := hash(t)
+			for _, seen := range buckets[h] {
+				if equals(seen, t) {
+					return
+				}
+			}
+			buckets[h] = append(buckets[h], t)
+			down.Accept(t)
+		}), end(func() {
+			buckets = nil
+			down.End()
+		}))
+	})
+}
+
+// Sorted sort by Comparator 排序
+func (s *stream) Sorted(comparator types.Comparator) Stream {
+	return s.sorted("Sorted", comparator, func(size int64) []types.T {
+		return make([]types.T, 0, capacityOf(size)) // 返回一个length=0, cap=size的slice
+	})
+}
+
+// SortedInto 同 Sorted, 但是使用调用方提供的 scratch 缓存元素, 避免每次排序都分配新的切片
+// SortedInto is Sorted which buffers elements in `scratch[:0]` instead of a new slice, for pipelines invoked
+// in a loop over many small datasets. the backing array is reused only if its capacity is enough, otherwise
+// append allocates a new one as usual. `scratch` is overwritten and keeps references to the elements after the
+// pipeline finishes, so it must not be used by other code, or by two pipelines running concurrently.
+func (s *stream) SortedInto(comparator types.Comparator, scratch []types.T) Stream {
+	return s.sorted("SortedInto", comparator, func(int64) []types.T {
+		return scratch[:0]
+	})
+}
+
+// buffer 在 begin 时调用, 返回用于缓存元素的空切片
+func (s *stream) sorted(name string, comparator types.Comparator, buffer func(size int64) []types.T) Stream {
+	return newNode(s, name, func(down stage) stage {
+		var list []types.T
+		return newChainedStage(down, begin(func(size int64) {
+			list = buffer(size)
+			// 下游在排序后才开始接收元素, 所以在 end 中才调用 down.Begin
+		}), action(func(t types.T) {
+			list = append(list, t)
+		}), end(func() {
+			a := &Sortable{
+				List: list,
+				Cmp: comparator,
+			}
+			sort.Sort(a)
+			down.Begin(int64(len(a.List)))
+			i := it(a.List...)
+			for i.HasNext() && !down.CanFinish() {
+				down.Accept(i.Next())
+			}
+			list = nil
+			a = nil
+			down.End()
+		}))
+	}).buffering()
+}
+
+// SortedDesc 降序排序, 同 Sorted(types.ReverseOrder(cmp))
+func (s *stream) SortedDesc(cmp types.Comparator) Stream {
+	return s.Sorted(types.ReverseOrder(cmp))
+}
+
+// SortedNaturalDesc 按自然顺序降序排序, 元素必须是数字或字符串, see types.NaturalOrder
+func (s *stream) SortedNaturalDesc() Stream {
+	return s.SortedDesc(types.NaturalOrder)
+}
+
+// SortedLimit 排序后取前 k 个元素, 等同于 Sorted(cmp).Limit(k)
+// SortedLimit keeps only the k smallest elements(by `cmp`) in a bounded heap, and emits them sorted at end,
+// so it costs O(n log k) time and O(k) memory instead of sorting everything. order of equal elements is not guaranteed.
+func (s *stream) SortedLimit(cmp types.Comparator, k int64) Stream {
+	if k < 0 {
+		k = 0
+	}
+	return s.sortedPage(fmt.Sprintf("SortedLimit(%d)", k), cmp, 0, k)
+}
+
+// SortedPage 排序后跳过 offset 个元素, 再取 limit 个元素, 等同于 Sorted(cmp).Skip(offset).Limit(limit)
+// SortedPage is for sorted pagination: it keeps only the offset+limit smallest elements(by `cmp`) in a bounded heap
+// like SortedLimit, then drops the first `offset` of them, so memory is O(offset+limit) regardless of input size.
+// order of equal elements is not guaranteed, so elements equal to each other may move across page boundaries,
+// use a comparator that breaks ties(e.g. by id) for stable pages. negative offset or limit panics with ErrNegativeSize.
+func (s *stream) SortedPage(cmp types.Comparator, offset, limit int64) Stream {
+	if offset < 0 || limit < 0 {
+		panic(ErrNegativeSize)
+	}
+	return s.sortedPage(fmt.Sprintf("SortedPage(%d,%d)", offset, limit), cmp, offset, limit)
+}
+
+// 用堆保留最小的 offset+limit 个元素, 排序后丢弃前 offset 个
+func (s *stream) sortedPage(name string, cmp types.Comparator, offset, limit int64) Stream {
+	k := int64(math.MaxInt64)
+	if limit == 0 {
+		k = 0 // 结果一定为空, 不需要保留任何元素
+	} else if limit <= k-offset {
+		k = offset + limit
+	}
+	return newNode(s, name, func(down stage) stage {
+		var h *boundedHeap
+		return newChainedStage(down, begin(func(size int64) {
+			h = newBoundedHeap(cmp, k, size)
+		}), action(func(t types.T) {
+			h.Offer(t)
+		}), end(func() {
+			list := h.Sorted()
+			h = nil
+			if offset < int64(len(list)) {
+				list = list[offset:]
+			} else {
+				list = nil
+			}
+			down.Begin(int64(len(list)))
+			i := it(list...)
+			for i.HasNext() && !down.CanFinish() {
+				down.Accept(i.Next())
+			}
+			down.End()
+		}))
+	}).buffering()
+}
+
+// Limit 限制元素个数
+func (s *stream) Limit(maxSize int64) Stream {
+	return newNode(s, fmt.Sprintf("Limit(%d)", maxSize), func(down stage) stage {
+		count := int64(0)
+		return newChainedStage(down, begin(func(size int64) {
+			down.Begin(limitSize(size, maxSize))
+		}), action(func(t types.T) {
+			if count < maxSize {
+				down.Accept(t)
+			}
+			count++
+		}), canFinish(func() bool {
+			return count >= maxSize		// 已经到了限制数量，就可以提前结束了
+		}))
+	}).bounding()
+}
+
+// LimitWeight 发送元素直到它们的重量之和将要超过 maxWeight, 然后提前结束
+// LimitWeight emits elements while the running sum of `weightFn` stays within `maxWeight`, e.g. a byte budget.
+// the first element which would make the sum exceed `maxWeight` is dropped and the stream finishes there,
+// even if later elements are light enough.
+func (s *stream) LimitWeight(maxWeight int64, weightFn func(t types.T) int64) Stream {
+	return newNode(s, fmt.Sprintf("LimitWeight(%d)", maxWeight), func(down stage) stage {
+		var weight int64
+		exceeded := false
+		return newChainedStage(down, begin(func(int64) {
+			weight, exceeded = 0, false
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			if exceeded {
+				return
+			}
+			w := weightFn(t)
+			if w > maxWeight-weight { // 避免 weight+w 溢出
+				exceeded = true
+				return
+			}
+			weight += w
+			down.Accept(t)
+		}), canFinish(func() bool {
+			return exceeded || down.CanFinish()
+		}))
+	}).bounding()
+}
+
+// TakeUntil 发送元素直到(包括)第一个满足条件的元素, 然后提前结束
+// TakeUntil emits elements up to and including the first element which satisfies `test`, then stops
+func (s *stream) TakeUntil(test types.Predicate) Stream {
+	return newNode(s, "TakeUntil", func(down stage) stage {
+		done := false
+		return newChainedStage(down, begin(func(int64) {
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			if done {
+				return
+			}
+			down.Accept(t)
+			done = test(t)
+		}), canFinish(func() bool {
+			return done || down.CanFinish()
+		}))
+	}).bounding()
+}
+
+// SKip 跳过指定个数的元素
+// a negative n is treated as 0, that is skip nothing
+func (s *stream) Skip(n int64) Stream {
+	if n < 0 {
+		n = 0
+	}
+	return newNode(s, fmt.Sprintf("Skip(%d)", n), func(down stage) stage {
+		count := int64(0)
+		return newChainedStage(down, begin(func(size int64) {
+			down.Begin(skipSize(size, n))
+		}), action(func(t types.T) {
+			if count >= n {
+				down.Accept(t)
+			}
+			count++
+		}))
+	})
+}
+
+// SkipN 同 Skip, 按个数跳过元素, 用于和按条件跳过的 SkipWhile 区分
+// SkipN is an alias of Skip, named to make the count-based intent explicit next to SkipWhile
+func (s *stream) SkipN(n int64) Stream {
+	return s.Skip(n)
+}
+
+// SkipWhile 跳过开头满足条件的元素, 遇到第一个不满足条件的元素后发送剩下的所有元素(即 DropWhile)
+// SkipWhile drops leading elements while `test` holds, then emits the rest, `test` is not called any more
+// after the first element failing it. it's also known as DropWhile.
+func (s *stream) SkipWhile(test types.Predicate) Stream {
+	return newNode(s, "SkipWhile", func(down stage) stage {
+		skipping := true
+		return newChainedStage(down, begin(func(int64) {
+			skipping = true
+			down.Begin(unkonwnSize)
+		}), action(func(t types.T) {
+			if skipping && test(t) {
+				return
+			}
+			skipping = false
+			down.Accept(t)
+		}))
+	})
+}
+
+// Buffer 缓存最多 size 个元素后再一起发送给下游
+// Buffer collects up to `size` elements before flushing them downstream, and flushes the rest at end.
+// in a sequential pipeline it's an ordering-preserving pass-through with periodic flush,
+// it marks the boundary of batch handoff between upstream and downstream. size < 1 is treated as 1.
+func (s *stream) Buffer(size int) Stream {
+	if size < 1 {
+		size = 1
+	}
+	return newNode(s, fmt.Sprintf("Buffer(%d)", size), func(down stage) stage {
+		var buffer []types.T
+		flush := func() {
+			for _, t := range buffer {
+				if down.CanFinish() {
+					break
+				}
+				down.Accept(t)
+			}
+			buffer = buffer[:0]
+		}
+		return newChainedStage(down, begin(func(n int64) {
+			capacity := int64(size)
+			if n >= 0 && n < capacity {
+				capacity = n
+			}
+			buffer = make([]types.T, 0, capacityOf(capacity))
+			down.Begin(n)
+		}), action(func(t types.T) {
+			buffer = append(buffer, t)
+			if len(buffer) >= size {
+				flush()
+			}
+		}), end(func() {
+			flush()
+			buffer = nil
+			down.End()
+		}))
+	}).keepingSize()
 }
 
-// end region stateless operate
-
-// region stateful operate 有状态操作
-
-// Distinct remove duplicate 去重操作
-// distincter is a IntFunction, which return a int hashcode to identity each element 返回元素的唯一标识用于区分每个元素
-func (s *stream) Distinct(distincter types.IntFunction) Stream {
-	return newNode(s, func(down stage) stage {
-		var set map[int]bool
+// WindowBy 把连续的 key 相同的元素放到同一个窗口([]types.T)中
+// WindowBy groups consecutive elements sharing the same key(compared by ==, so keys must be comparable)
+// into a []types.T window, a window is emitted whenever the key changes, and the last window is emitted at end.
+// it's usually used on data already sorted by the key.
+func (s *stream) WindowBy(keyFn types.Function) Stream {
+	return newNode(s, "WindowBy", func(down stage) stage {
+		var key types.R
+		var window []types.T
 		return newChainedStage(down, begin(func(int64) {
-			set = make(map[int]bool)
-			down.Begin(unkonwnSize)		// 去重后的个数不确定
+			window = nil
+			down.Begin(unkonwnSize)
 		}), action(func(t types.T) {
-			hash := distincter(t)
-			if _, ok := set[hash]; !ok { // 唯一的元素才往下游发送
-				set[hash] = true
-				down.Accept(t)
+			k := keyFn(t)
+			if len(window) > 0 && k != key {
+				down.Accept(window)
+				window = nil
 			}
+			key = k
+			window = append(window, t)
 		}), end(func() {
-			set = nil
+			if len(window) > 0 && !down.CanFinish() {
+				down.Accept(window)
+			}
+			window = nil
 			down.End()
 		}))
 	})
 }
 
-// Sorted sort by Comparator 排序
-func (s *stream) Sorted(comparator types.Comparator) Stream {
-	return newNode(s, func(down stage) stage {
-		var list []types.T
-		return newChainedStage(down, begin(func(size int64) {
-			if size > 0 {
-				list = make([]types.T, 0, size) // 返回一个length=0, cap=size的slice
-			} else {
-				list = make([]types.T, 0)
+// ChunkOf 每 size 个元素组成一个 []typ 类型的切片发送给下游, 最后不足 size 个的元素也组成一个切片
+// ChunkOf emits consecutive elements as chunks of the concrete slice type []typ(made by reflect.MakeSlice, like
+// ToSliceOf), so a chunk can be passed to APIs expecting e.g. []Record directly. the final chunk may be shorter.
+// each chunk is a new slice. a nil element becomes the zero value of `typ`, and a element which is not assignable
+// to `typ` panics with a error wrapping ErrNotAssignable. size <= 0 panics with ErrNonPositiveSize.
+func (s *stream) ChunkOf(typ reflect.Type, size int) Stream {
+	if size <= 0 {
+		panic(ErrNonPositiveSize)
+	}
+	sliceType := reflect.SliceOf(typ)
+	return newNode(s, fmt.Sprintf("ChunkOf(%v,%d)", typ, size), func(down stage) stage {
+		var chunk reflect.Value
+		return newChainedStage(down, begin(func(n int64) {
+			chunk = reflect.Value{}
+			if n > 0 {
+				chunks := n / int64(size)
+				if n%int64(size) != 0 {
+					chunks++
+				}
+				n = chunks
 			}
-			down.Begin(size)
+			down.Begin(n)
 		}), action(func(t types.T) {
-			list = append(list, t)
-		}), end(func() {
-			a := &Sortable{
-				List: list,
-				Cmp: comparator,
+			if t != nil && !assignable(t, typ) {
+				panic(fmt.Errorf("%w: %T to %v", ErrNotAssignable, t, typ))
 			}
-			sort.Sort(a)
-			down.Begin(int64(len(a.List)))
-			i := it(a.List...)
-			for i.HasNext() && !down.CanFinish() {
-				down.Accept(i.Next())
+			if !chunk.IsValid() {
+				chunk = reflect.MakeSlice(sliceType, 0, capacityOf(int64(size)))
 			}
-			list = nil
-			a = nil
+			chunk = reflect.Append(chunk, valueOf(t, typ))
+			if chunk.Len() == size {
+				down.Accept(chunk.Interface())
+				chunk = reflect.Value{}
+			}
+		}), end(func() {
+			if chunk.IsValid() && !down.CanFinish() {
+				down.Accept(chunk.Interface())
+			}
+			chunk = reflect.Value{}
 			down.End()
 		}))
 	})
 }
 
-// Limit 限制元素个数
-func (s *stream) Limit(maxSize int64) Stream {
-	return newNode(s, func(down stage) stage {
-		count := int64(0)
-		return newChainedStage(down, begin(func(size int64) {
-			if size > 0 && size > maxSize {
-				size = maxSize
+// WindowReduce 滑动窗口归约: 每个窗口有 size 个元素, 相邻窗口的起点相差 step 个元素, 发送每个窗口归约后的结果
+// WindowReduce slides a window of `size` elements by `step` elements, and emits reduce(window) for each window,
+// e.g. a moving average. windows start at the 0th, step-th, 2*step-th... element like Kotlin's windowed, so
+// windows overlap if step < size and elements between windows are skipped if step > size.
+// if `partial` is true, the trailing windows which have fewer than `size` elements are reduced as well.
+// the window slice is reused, so `reduce` must not retain it. size or step <= 0 panics with ErrNonPositiveSize.
+func (s *stream) WindowReduce(size, step int, partial bool, reduce func(window []types.T) types.R) Stream {
+	if size <= 0 || step <= 0 {
+		panic(ErrNonPositiveSize)
+	}
+	return newNode(s, fmt.Sprintf("WindowReduce(%d,%d)", size, step), func(down stage) stage {
+		var window []types.T
+		skip := 0 // step > size 时, 下一个窗口开始前需要跳过的元素个数
+		slide := func() {
+			if step >= len(window) {
+				skip = step - len(window)
+				window = window[:0]
+				return
 			}
-			down.Begin(size)
+			n := copy(window, window[step:])
+			window = window[:n]
+		}
+		return newChainedStage(down, begin(func(int64) {
+			window = make([]types.T, 0, capacityOf(int64(size)))
+			skip = 0
+			down.Begin(unkonwnSize)
 		}), action(func(t types.T) {
-			if count < maxSize {
-				down.Accept(t)
+			if skip > 0 {
+				skip--
+				return
 			}
-			count++
-		}), canFinish(func() bool {
-			return count >= maxSize		// 已经到了限制数量，就可以提前结束了
+			window = append(window, t)
+			if len(window) == size {
+				down.Accept(reduce(window))
+				slide()
+			}
+		}), end(func() {
+			for partial && len(window) > 0 && !down.CanFinish() {
+				down.Accept(reduce(window))
+				slide()
+			}
+			window = nil
+			down.End()
 		}))
 	})
 }
 
-// SKip 跳过指定个数的元素
-func (s *stream) Skip(n int64) Stream {
-	return newNode(s, func(down stage) stage {
-		count := int64(0)
-		return newChainedStage(down, begin(func(size int64) {
-			if size > 0 {
-				size -= n
-				if size < 0 {
-					size = 0
-				}
-			}
-			down.Begin(size)
+// GroupByToStream 按 classifier 分组, 每组作为一个 types.Pair{First: key, Second: []types.T} 发送给下游
+// GroupByToStream groups elements by the key `classifier` returns(keys must be comparable), and emits
+// a types.Pair{First: key, Second: []types.T} for each group at end, in the order the keys were first seen.
+// like Sorted it buffers all elements, unlike WindowBy the elements needn't be sorted by the key.
+func (s *stream) GroupByToStream(classifier types.Function) Stream {
+	return newNode(s, "GroupByToStream", func(down stage) stage {
+		var keys []types.R
+		var groups map[types.R][]types.T
+		return newChainedStage(down, begin(func(int64) {
+			keys = nil
+			groups = make(map[types.R][]types.T)
+			// 分组完成后下游才开始接收元素, 所以在 end 中才调用 down.Begin
 		}), action(func(t types.T) {
-			if count >= n {
-				down.Accept(t)
+			key := classifier(t)
+			group, ok := groups[key]
+			if !ok {
+				keys = append(keys, key)
 			}
-			count++
+			groups[key] = append(group, t)
+		}), end(func() {
+			down.Begin(int64(len(keys)))
+			for _, key := range keys {
+				if down.CanFinish() {
+					break
+				}
+				down.Accept(types.Pair{
+					First:  key,
+					Second: groups[key],
+				})
+			}
+			keys, groups = nil, nil
+			down.End()
 		}))
-	})
+	}).buffering()
+}
+
+// SplitAt 把流分为前 n 个元素(head)和剩下的元素(tail)两个流
+// SplitAt splits the stream into `head` with the first n elements and `tail` with the rest, e.g. a header row
+// and the data rows. both share the single-use source, which is pulled lazily when they're consumed:
+// consume `head` first, then `tail`, and they reproduce the original sequence exactly. elements of `head`
+// not consumed when `tail` starts are skipped, then `head` is empty. each of them can be consumed only once.
+// the stream is closed when `tail` is exhausted or closed. n < 0 is treated as 0.
+func (s *stream) SplitAt(n int64) (head Stream, tail Stream) {
+	if n < 0 {
+		n = 0
+	}
+	pull := withPull(s)
+	remaining := n
+	h := newHead(&splitIt{pull: pull, remaining: &remaining, head: true})
+	t := newHead(&splitIt{pull: pull, remaining: &remaining})
+	t.onClose = pull.close
+	return h, t
 }
 
 // end region stateful operate 有状态操作
 
 // region terminate operate 终止操作
 // ForEach消费流中的每个元素
+// ForEach is allowed on a infinite stream, it runs until the consumer panics.
 func (s *stream) ForEach(consumer types.Consumer) {
-	s.terminal(newTerminalStage(consumer))
+	s.terminal(newTerminalStage(consumer, canFinish(func() bool {
+		return false
+	})))
+}
+
+// ForEachUntil 消费每个元素, consumer 返回 false 时停止遍历
+// ForEachUntil calls `consumer` for each element until it returns false, the element for which it returned false
+// has been consumed already. the decision may depend on the state accumulated by `consumer`.
+// like ForEach it's allowed on a infinite stream.
+func (s *stream) ForEachUntil(consumer func(t types.T) bool) {
+	stop := false
+	s.terminal(newTerminalStage(func(t types.T) {
+		if !stop {
+			stop = !consumer(t)
+		}
+	}, canFinish(func() bool {
+		return stop
+	})))
+}
+
+// ForEachCount 消费每个元素, 并返回消费的元素个数
+// ForEachCount like ForEach, but returns how many elements were consumed, the source can't be traversed again for Count
+func (s *stream) ForEachCount(consumer types.Consumer) int64 {
+	count := int64(0)
+	s.ForEach(func(t types.T) {
+		consumer(t)
+		count++
+	})
+	return count
+}
+
+// ForEachBatch 每攒够 size 个元素调用一次 sink, 最后不足 size 个的元素在结束时调用
+// ForEachBatch accumulates up to `size` elements and calls `sink` with them, the final partial batch is flushed at end.
+// each batch is a new slice, so `sink` may keep it. size <= 0 panics with ErrNonPositiveSize.
+// like ForEach it's allowed on a infinite stream.
+func (s *stream) ForEachBatch(size int, sink func(batch []types.T)) {
+	if size <= 0 {
+		panic(ErrNonPositiveSize)
+	}
+	var batch []types.T
+	s.terminal(newTerminalStage(func(t types.T) {
+		if batch == nil {
+			batch = make([]types.T, 0, capacityOf(int64(size)))
+		}
+		batch = append(batch, t)
+		if len(batch) == size {
+			sink(batch)
+			batch = nil
+		}
+	}, canFinish(func() bool {
+		return false
+	}), end(func() {
+		if len(batch) > 0 {
+			sink(batch)
+			batch = nil
+		}
+	})))
+}
+
+// ForEachOrdered 按流中元素的顺序消费每个元素
+// ForEachOrdered is guaranteed to visit elements in encounter order, even if a parallel mode is added to ForEach later.
+// executed sequentially it behaves identically to ForEach.
+func (s *stream) ForEachOrdered(consumer types.Consumer) {
+	s.ForEach(consumer)
 }
 
 func (s *stream) ToSlice() []types.T {
 	return s.ReduceBy(func(count int64) types.R {
-		if count >= 0 {
-			return make([]types.T, 0, count)
-		}
-		return make([]types.T, 0)
+		return make([]types.T, 0, capacityOf(count))
 	}, func(acc types.R, e types.T) types.R {
 		slice := acc.([]types.T)
 		slice = append(slice, e)
@@ -244,6 +1488,43 @@ func (s *stream) ToSlice() []types.T {
 	}).([]types.T)
 }
 
+// ToSliceInto 把元素追加到 dst 后返回, 可以复用 dst 的底层数组
+// ToSliceInto appends the elements to `dst` and returns the result like the built-in append,
+// pass `dst[:0]` to reuse the backing array across invocations without allocating.
+// if the size is known and `dst` lacks capacity, it grows once before appending.
+func (s *stream) ToSliceInto(dst []types.T) []types.T {
+	s.terminal(newTerminalStage(func(t types.T) {
+		dst = append(dst, t)
+	}, begin(func(size int64) {
+		if n := capacityOf(size); cap(dst)-len(dst) < n {
+			grown := make([]types.T, len(dst), len(dst)+n)
+			copy(grown, dst)
+			dst = grown
+		}
+	})))
+	return dst
+}
+
+// SetSlice 去重后转为切片, 同 Distinct(distincter).ToSlice(), 但只需一次遍历
+// SetSlice returns the unique elements in first-seen order, elements which `distincter` returns the same int
+// are treated as duplicates. the result is a non-nil empty slice if the stream is empty.
+func (s *stream) SetSlice(distincter types.IntFunction) []types.T {
+	result := make([]types.T, 0)
+	var set map[int]bool
+	s.terminal(newTerminalStage(func(t types.T) {
+		hash := distincter(t)
+		if _, ok := set[hash]; !ok {
+			set[hash] = true
+			result = append(result, t)
+		}
+	}, begin(func(size int64) {
+		n := capacityOf(size)
+		set = make(map[int]bool, n)
+		result = make([]types.T, 0, n)
+	})))
+	return result
+}
+
 // ToElementSlice needs a argument cause the stream may be empty
 func (s *stream) ToElementSlice(some types.T) types.R {
 	return s.ToSliceOf(reflect.TypeOf(some))
@@ -254,17 +1535,40 @@ func (s *stream) ToSliceOf(typ reflect.Type) types.R {
 	sliceType := reflect.SliceOf(typ)	// 返回类型typ对应的切片类型
 	return s.ReduceBy(func(size int64) types.R {
 		if size >= 0 {
-			return reflect.MakeSlice(sliceType, 0, int(size))
+			return reflect.MakeSlice(sliceType, 0, capacityOf(size))
 		}
 		return reflect.MakeSlice(sliceType, 0, 16)
 	}, func(acc types.R, e types.T) types.R {
 		sliceValue := acc.(reflect.Value)
-		sliceValue = reflect.Append(sliceValue, reflect.ValueOf(e))
+		sliceValue = reflect.Append(sliceValue, valueOf(e, typ))
 		return sliceValue
 	}).(reflect.Value).Interface()
 }
 
+// ToMapOf return map[K]V which K, V is same as the `keyType`, `valueType` representation.
+// key and value of each element are computed by `keyFn` and `valueFn`, nil key or value is converted to the zero value.
+// `keyType` must be comparable or it will panic, and the later element wins when keys are duplicated.
+func (s *stream) ToMapOf(keyType, valueType reflect.Type, keyFn, valueFn types.Function) types.R {
+	mapType := reflect.MapOf(keyType, valueType)
+	return s.ReduceBy(func(size int64) types.R {
+		if size >= 0 {
+			return reflect.MakeMapWithSize(mapType, int(size))
+		}
+		return reflect.MakeMap(mapType)
+	}, func(acc types.R, e types.T) types.R {
+		mapValue := acc.(reflect.Value)
+		mapValue.SetMapIndex(valueOf(keyFn(e), keyType), valueOf(valueFn(e), valueType))
+		return mapValue
+	}).(reflect.Value).Interface()
+}
+
 func (s *stream) Reduce(accumulator types.BinaryOperator) optional.Optional {
+	result, _ := s.ReduceOk(accumulator)
+	return optional.OfNullable(result)
+}
+
+// ReduceOk like Reduce, but returns the result and whether the stream has any element
+func (s *stream) ReduceOk(accumulator types.BinaryOperator) (types.T, bool) {
 	var result types.T = nil
 	var hasElement = false
 	s.terminal(newTerminalStage(func(t types.T) {
@@ -276,6 +1580,38 @@ func (s *stream) Reduce(accumulator types.BinaryOperator) optional.Optional {
 		}
 	}))
 
+	return result, hasElement
+}
+
+// ReduceCounting 同 Reduce, 同时返回归约的元素个数, 流为空时返回 optional.Empty 和 0
+// ReduceCounting folds elements like Reduce and also returns how many elements were folded in the same pass,
+// e.g. a sum and the count to derive an average.
+func (s *stream) ReduceCounting(accumulator types.BinaryOperator) (result optional.Optional, count int64) {
+	var acc types.T
+	s.terminal(newTerminalStage(func(t types.T) {
+		if count == 0 {
+			acc = t
+		} else {
+			acc = accumulator(acc, t)
+		}
+		count++
+	}))
+	return optional.OfNullable(acc), count
+}
+
+// ReduceRight 从最后一个元素开始向前归约, 即 accumulator(e1, accumulator(e2, ... accumulator(en-1, en)))
+// ReduceRight folds elements from last to first, the first argument of `accumulator` is the element
+// and the second is the folded result of the elements after it. return optional.Empty if no element.
+// iterators are forward-only, so all elements are buffered before folding, it never returns on an infinite stream.
+func (s *stream) ReduceRight(accumulator types.BinaryOperator) optional.Optional {
+	list := s.ToSlice()
+	if len(list) == 0 {
+		return optional.Empty()
+	}
+	result := list[len(list)-1]
+	for i := len(list) - 2; i >= 0; i-- {
+		result = accumulator(list[i], result)
+	}
 	return optional.OfNullable(result)
 }
 
@@ -289,6 +1625,73 @@ func (s *stream) ReduceFrom(initValue types.T, accumulator types.BinaryOperator)
 	return result
 }
 
+// ReduceIdentity 完整的三参数归约, 顺序执行时等同于 ReduceFrom, combiner 仅用于并行执行时合并部分结果
+// ReduceIdentity is the three-argument reduce. `identity` must be an identity value for the `combiner`,
+// that is combiner(identity, t) equals t for any t, and `combiner` must be compatible with `accumulator`:
+// combiner(u, accumulator(identity, t)) equals accumulator(u, t).
+// These requirements are not checked, executed sequentially the `combiner` is never called.
+func (s *stream) ReduceIdentity(identity types.T, accumulator types.BinaryOperator, combiner types.BinaryOperator) types.T {
+	return s.ReduceFrom(identity, accumulator)
+}
+
+// ParallelReduce 并行归约: 把所有元素分成 parallelism 段, 每段在单独的 goroutine 中从 identity 开始用 accumulator 归约,
+// 最后按段的顺序用 combiner 合并部分结果
+// ParallelReduce materializes the elements, splits them into `parallelism` contiguous chunks reduced
+// by separate goroutines from `identity` with `accumulator`, then combines the partial results in chunk order
+// with `combiner`. the requirements are the same as ReduceIdentity: `accumulator` must be associative,
+// `identity` must be an identity value for `combiner`, and `combiner` must be compatible with `accumulator`,
+// otherwise the result depends on how elements are chunked(streamtest.CheckAssociative can check them on samples).
+// all functions must be safe for concurrent use.
+// a panic in any goroutine is re-raised in the caller after all goroutines finish.
+// returns `identity` for an empty stream, parallelism < 1 is treated as 1.
+func (s *stream) ParallelReduce(parallelism int, identity types.T, accumulator, combiner types.BinaryOperator) types.T {
+	list := s.ToSlice()
+	if len(list) == 0 {
+		return identity
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(list) {
+		parallelism = len(list)
+	}
+	chunk := (len(list) + parallelism - 1) / parallelism
+	chunks := (len(list) + chunk - 1) / chunk
+	// 先分配好, goroutine 只写自己的下标
+	partials := make([]types.T, chunks)
+	recovered := make([]interface{}, chunks)
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		from, to := i*chunk, (i+1)*chunk
+		if to > len(list) {
+			to = len(list)
+		}
+		wg.Add(1)
+		go func(i int, elements []types.T) {
+			defer wg.Done()
+			defer func() {
+				recovered[i] = recover()
+			}()
+			acc := identity
+			for _, t := range elements {
+				acc = accumulator(acc, t)
+			}
+			partials[i] = acc
+		}(i, list[from:to])
+	}
+	wg.Wait()
+	for _, r := range recovered {
+		if r != nil {
+			panic(r)
+		}
+	}
+	result := partials[0]
+	for _, partial := range partials[1:] {
+		result = combiner(result, partial)
+	}
+	return result
+}
+
 // ReduceWith 使用给定的初始值 initValue(类型和元素类型不同) 开始迭代 使用 accumulator( R + T -> R) 累计结果
 func (s *stream) ReduceWith(initValue types.R, accumulator func(acc types.R, e types.T) types.R) types.R {
 	var result = initValue
@@ -327,15 +1730,326 @@ func (s *stream) FindFirst() optional.Optional {
 	return optional.OfNullable(result)
 }
 
+// FindAny 返回任意一个元素并提前结束, 流为空时返回 optional.Empty
+// FindAny returns some element and short-circuits, it's not guaranteed to be the first in encounter order,
+// so a future parallel mode can return whichever element is found first. executed sequentially it's FindFirst.
+func (s *stream) FindAny() optional.Optional {
+	return s.FindFirst()
+}
+
+// TopN 返回最大的 n 个元素, 按从大到小排列
+// TopN returns the n greatest elements by `cmp` in descending order(fewer if the stream is shorter),
+// using a bounded heap so that memory is O(n) regardless of stream length. ties are broken arbitrarily.
+func (s *stream) TopN(n int, cmp types.Comparator) []types.T {
+	if n < 0 {
+		n = 0
+	}
+	var h *boundedHeap
+	s.terminal(newTerminalStage(func(t types.T) {
+		h.Offer(t)
+	}, begin(func(size int64) {
+		h = newBoundedHeap(types.ReverseOrder(cmp), int64(n), size)
+	})))
+	return h.Sorted()
+}
+
+// Last 返回最后一个元素, 流为空时返回 optional.Empty
+// Last returns the final element. iterators are forward-only, so it always traverses the whole stream,
+// even if the source size is known.
+func (s *stream) Last() optional.Optional {
+	var result types.T = nil
+	s.terminal(newTerminalStage(func(t types.T) {
+		result = t
+	}))
+	return optional.OfNullable(result)
+}
+
+// MinMax 一次遍历同时找出最小和最大的元素, 流为空时都返回 optional.Empty
+// MinMax finds both the minimum and the maximum by `cmp` in a single traversal.
+// the first one wins if several elements are equally minimal or maximal.
+func (s *stream) MinMax(cmp types.Comparator) (min optional.Optional, max optional.Optional) {
+	var minT, maxT types.T
+	first := true
+	s.terminal(newTerminalStage(func(t types.T) {
+		if first {
+			minT, maxT = t, t
+			first = false
+			return
+		}
+		if cmp(t, minT) < 0 {
+			minT = t
+		}
+		if cmp(t, maxT) > 0 {
+			maxT = t
+		}
+	}))
+	return optional.OfNullable(minT), optional.OfNullable(maxT)
+}
+
+// Head 返回前 n 个元素, 取满后提前结束
+// Head returns the first up-to-n elements as a non-nil slice, it stops pulling elements once the slice is full
+func (s *stream) Head(n int) []types.T {
+	if n < 0 {
+		n = 0
+	}
+	result := make([]types.T, 0)
+	s.terminal(newTerminalStage(func(t types.T) {
+		result = append(result, t)
+	}, begin(func(size int64) {
+		if size < 0 || size > int64(n) { // 不超过 n, 也不超过已知的元素个数
+			size = int64(n)
+		}
+		result = make([]types.T, 0, capacityOf(size))
+	}), canFinish(func() bool {
+		return len(result) >= n
+	})))
+	return result
+}
+
 // Count 计算元素个数
+// if the source size is known and no operate may change the count or has side effects
+// (e.g. OnClose, Keys, Values, Enumerate, Buffer; not Sorted, which calls the comparator), Count returns the size without traversal.
+// sources which call user functions(GenerateN, IterateN, MergeByKey) are always traversed, so the functions are called as before.
 func (s *stream) Count() int64 {
+	if size := s.passThroughSize(); size >= 0 {
+		s.close()
+		return size
+	}
 	return s.ReduceWith(int64(0), func(count types.R, t types.T) types.R {
 		return count.(int64) + 1
 	}).(int64)
 }
 
 
-// 测试是否所有元素满足条件
+// Collect 使用 Collector 归约所有元素
+// Collect performs a mutable reduction by the given Collector
+func (s *stream) Collect(collector Collector) types.R {
+	return collector.Finish(s.ReduceBy(collector.Supply, collector.Accumulate))
+}
+
+// Teeing 一次遍历同时把每个元素交给两个 Collector, 最后用 merge 合并两个结果
+// Teeing feeds each element to both collectors at Accept time, so the source is traversed only once.
+func (s *stream) Teeing(c1, c2 Collector, merge func(r1, r2 types.R) types.R) types.R {
+	return s.Collect(TeeingCollector(c1, c2, merge))
+}
+
+// PairsToMap 把 types.Pair 元素转为 map, First 为 key, Second 为 value
+// PairsToMap builds a non-nil map from First to Second of each types.Pair element, panic with ErrNotPair on other elements.
+// when keys are duplicated, the last one wins.
+func (s *stream) PairsToMap() map[types.R]types.R {
+	result := make(map[types.R]types.R)
+	s.terminal(newTerminalStage(func(t types.T) {
+		pair := asPair(t)
+		result[pair.First] = pair.Second
+	}))
+	return result
+}
+
+// PartitionErrors 把 types.Pair{First: 值, Second: error} 元素分为值和错误两部分
+// PartitionErrors separates results in one pass, each element must be a types.Pair whose First is the value
+// and Second is a error or nil, like the results of a function returning (value, error).
+// values of pairs with nil error go to `values`, the non-nil errors go to `errs`, both are non-nil slices.
+// it panics with ErrNotPair if a element is not a types.Pair or its Second is neither nil nor a error.
+func (s *stream) PartitionErrors() (values []types.T, errs []error) {
+	values, errs = make([]types.T, 0), make([]error, 0)
+	s.terminal(newTerminalStage(func(t types.T) {
+		pair := asPair(t)
+		if pair.Second == nil {
+			values = append(values, pair.First)
+			return
+		}
+		err, ok := pair.Second.(error)
+		if !ok {
+			panic(fmt.Errorf("%w: Second is %T, not error", ErrNotPair, pair.Second))
+		}
+		errs = append(errs, err)
+	}))
+	return values, errs
+}
+
+// ToMapReducing 按 keyFn 分组, 每组的 valueFn 结果用 merge 归约
+// ToMapReducing groups elements by `keyFn`(keys must be comparable) and folds the values `valueFn` returns
+// in one pass without intermediate slices: the first value of a key is stored as is, each later value is
+// merged by merge(stored, value), so `merge` is called only for duplicated keys, in encounter order.
+// e.g. summing order totals per customer id. returns a non-nil map.
+func (s *stream) ToMapReducing(keyFn types.Function, valueFn types.Function, merge types.BinaryOperator) map[types.R]types.R {
+	result := make(map[types.R]types.R)
+	s.terminal(newTerminalStage(func(t types.T) {
+		key, value := keyFn(t), valueFn(t)
+		if old, ok := result[key]; ok {
+			value = merge(old, value)
+		}
+		result[key] = value
+	}))
+	return result
+}
+
+// GroupByThen 按 classifier 的结果分组, 每组元素用 downstream 归约
+// GroupByThen groups elements by the key `classifier` returns(which must be comparable),
+// and folds each group by the `downstream` Collector in one pass, like Collectors.groupingBy(classifier, downstream).
+// use ToSliceCollector as `downstream` to collect each group into a []types.T.
+func (s *stream) GroupByThen(classifier types.Function, downstream Collector) map[types.R]types.R {
+	result := make(map[types.R]types.R)
+	s.terminal(newTerminalStage(func(t types.T) {
+		key := classifier(t)
+		acc, ok := result[key]
+		if !ok {
+			acc = downstream.Supply(unkonwnSize)
+		}
+		result[key] = downstream.Accumulate(acc, t)
+	}))
+	for key, acc := range result {
+		result[key] = downstream.Finish(acc)
+	}
+	return result
+}
+
+// Async 在新的 goroutine 中执行流, 通过 channel 返回结果
+// Async runs the pipeline in a new goroutine, streaming results on the first channel with the given buffer size.
+// if the pipeline panics, the panic value is sent as an error on the second channel.
+// cancel `ctx` to abort the goroutine when the consumer stops reading(ctx.Err() is sent on the second channel),
+// or the goroutine will block on sending forever.
+// both channels are closed at completion, so read the first channel until closed then read the second for error.
+func (s *stream) Async(ctx context.Context, buffer int) (<-chan types.T, <-chan error) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	out := make(chan types.T, buffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(out)
+		defer func() {
+			if r := recover(); r != nil {
+				if err, ok := r.(error); ok {
+					errs <- err
+				} else {
+					errs <- fmt.Errorf("%v", r)
+				}
+			}
+		}()
+		cancelled := false // 是否因为 ctx 被取消而提前结束, 结束后才取消的 ctx 不算错误
+		s.terminal(newTerminalStage(func(t types.T) {
+			select {
+			case out <- t:
+			case <-ctx.Done():
+				cancelled = true
+			}
+		}, canFinish(func() bool {
+			if ctx.Err() != nil {
+				cancelled = true
+			}
+			return cancelled
+		})))
+		if cancelled {
+			errs <- ctx.Err()
+		}
+	}()
+	return out, errs
+}
+
+// JoiningMap 把每个元素转为字符串后用 sep 连接
+// JoiningMap converts each element to string by `toString`(which must return a string) and joins them with `sep`.
+// returns empty string for an empty stream.
+func (s *stream) JoiningMap(toString types.Function, sep string) string {
+	var sb strings.Builder
+	first := true
+	s.terminal(newTerminalStage(func(t types.T) {
+		if !first {
+			sb.WriteString(sep)
+		}
+		first = false
+		sb.WriteString(toString(t).(string))
+	}))
+	return sb.String()
+}
+
+// JoiningLimit 最多连接 maxElems 个元素, 还有更多元素时在末尾追加 ellipsis
+// JoiningLimit joins at most `maxElems` elements like JoiningMap, and appends `ellipsis` right after them
+// if more elements were available, e.g. "1,2,3..." for maxElems 3 and ellipsis "...".
+// it stops pulling elements once the extra element is seen, which is never passed to `toString`.
+// an empty stream returns empty string, and maxElems <= 0 returns only `ellipsis` for a non-empty stream.
+func (s *stream) JoiningLimit(toString types.Function, sep string, maxElems int, ellipsis string) string {
+	var sb strings.Builder
+	count, more := 0, false
+	s.terminal(newTerminalStage(func(t types.T) {
+		if count >= maxElems {
+			more = true
+			return
+		}
+		if count > 0 {
+			sb.WriteString(sep)
+		}
+		count++
+		sb.WriteString(toString(t).(string))
+	}, canFinish(func() bool {
+		return more
+	})))
+	if more {
+		sb.WriteString(ellipsis)
+	}
+	return sb.String()
+}
+
+// ToJSON 把所有元素编码为 JSON 数组写入 w
+// ToJSON writes the elements as a JSON array to `w`, each element is encoded by encoding/json and written immediately,
+// then `w` is flushed if it has a `Flush() error` or `Flush()` method(e.g. *bufio.Writer, http.Flusher).
+// the first encoding or writing error stops the iteration and is returned, the output is incomplete then.
+func (s *stream) ToJSON(w io.Writer) error {
+	var err error
+	write := func(p []byte) {
+		if _, err = w.Write(p); err != nil {
+			return
+		}
+		switch f := w.(type) {
+		case interface{ Flush() error }:
+			err = f.Flush()
+		case interface{ Flush() }:
+			f.Flush()
+		}
+	}
+	write([]byte("["))
+	first := true
+	s.terminal(newTerminalStage(func(t types.T) {
+		data, e := json.Marshal(t)
+		if e != nil {
+			err = e
+			return
+		}
+		if !first {
+			data = append([]byte(","), data...)
+		}
+		first = false
+		write(data)
+	}, canFinish(func() bool {
+		return err != nil
+	})))
+	if err != nil {
+		return err
+	}
+	write([]byte("]"))
+	return err
+}
+
+// ToWriter 把每个元素格式化后写入 w, 返回写入的字节数和错误
+// ToWriter writes the bytes `format` returns for each element to `w`, returns the total bytes written and the first error.
+// stages can't return errors, so the error is kept in the terminal stage and reported by its CanFinish:
+// once a write fails, the terminal loop stops pulling elements from the source, and upstream
+// operates which are flushing at end(e.g. Sorted) stop sending elements as well.
+func (s *stream) ToWriter(w io.Writer, format func(t types.T) []byte) (int64, error) {
+	var written int64
+	var err error
+	s.terminal(newTerminalStage(func(t types.T) {
+		var n int
+		n, err = w.Write(format(t))
+		written += int64(n)
+	}, canFinish(func() bool {
+		return err != nil
+	})))
+	return written, err
+}
+
+// 测试是否所有元素满足条件, 空的流返回 true
 func (s *stream) AllMatch(test types.Predicate) bool {
 	result := true
 	s.terminal(newTerminalStage(func(t types.T) {
@@ -348,7 +2062,7 @@ func (s *stream) AllMatch(test types.Predicate) bool {
 	return result
 }
 
-// 测试是否没有元素满足条件
+// 测试是否没有元素满足条件, 空的流返回 true
 func (s *stream) NoneMatch(test types.Predicate) bool {
 	result := true
 	s.terminal(newTerminalStage(func(t types.T) {
@@ -361,7 +2075,7 @@ func (s *stream) NoneMatch(test types.Predicate) bool {
 	return result
 }
 
-// 测试有任意元素满足条件
+// 测试有任意元素满足条件, 空的流返回 false
 func (s *stream) AnyMatch(test types.Predicate) bool {
 	result := false
 	s.terminal(newTerminalStage(func(t types.T) {
@@ -374,6 +2088,22 @@ func (s *stream) AnyMatch(test types.Predicate) bool {
 	return result
 }
 
+// AnyMatchIndexed 测试是否有任意元素和它的下标满足条件, 下标从 0 开始
+// AnyMatchIndexed returns true once an element and its 0-based index satisfy `test`, false for an empty stream
+func (s *stream) AnyMatchIndexed(test func(index int64, t types.T) bool) bool {
+	result := false
+	index := int64(0)
+	s.terminal(newTerminalStage(func(t types.T) {
+		if test(index, t) {
+			result = true
+		}
+		index++
+	}, canFinish(func() bool {
+		return result
+	})))
+	return result
+}
+
 // end region terminate operate
 
 
@@ -1,16 +1,28 @@
 package stream
 
 import (
+	"bufio"
+	"container/heap"
 	"github.com/rhzx3519/stream/types"
+	"io"
+	"math"
 	"reflect"
+	"runtime"
+	"sort"
+	"sync"
 )
 
 const unkonwnSize  = -1
 
+// 按 size 预分配容量的上限, 避免过大的 size 导致一次性申请过多内存
+const maxPreallocSize = 1 << 20
+
 type iterator interface {
 	GetSizeIfKnown() int64
 	HasNext() bool
 	Next() types.T
+	// IsInfinite returns true if HasNext is permanently true
+	IsInfinite() bool
 }
 
 // 创建切片迭代器
@@ -40,6 +52,67 @@ func withSupplier(get types.Supplier) iterator {
 	}
 }
 
+// 创建限定个数的迭代器
+func withCount(source iterator, count int64) iterator {
+	if count < 0 {
+		count = 0
+	}
+	return &countIt{
+		source:    source,
+		remaining: count,
+	}
+}
+
+// 创建按行读取的迭代器
+func withLines(r io.Reader) iterator {
+	return &linesIt{
+		scanner: bufio.NewScanner(r),
+	}
+}
+
+// 创建循环迭代器, times < 0 表示无限循环
+func withCycle(elements []types.T, times int) iterator {
+	if len(elements) == 0 {
+		times = 0
+	}
+	return &cycleIt{
+		elements: elements,
+		times:    times,
+	}
+}
+
+// 创建回调生成器的迭代器
+func withGenerator(produce func(emit types.Consumer)) *generatorIt {
+	return &generatorIt{
+		produce: produce,
+		ch:      make(chan types.T),
+		done:    make(chan struct{}),
+	}
+}
+
+// 创建依次遍历多个迭代器的迭代器
+func withConcat(its []iterator) iterator {
+	return &concatIt{
+		its: its,
+	}
+}
+
+// 创建多路归并迭代器
+func withMerge(its []iterator, keyFn types.Function, keyCmp types.Comparator) iterator {
+	return &mergeIt{
+		its:    its,
+		keyFn:  keyFn,
+		keyCmp: keyCmp,
+	}
+}
+
+// 创建把流转为拉取方式的迭代器
+func withPull(s *stream) *pullIt {
+	return &pullIt{
+		s: s,
+	}
+}
+
 // 创建范围迭代器
 func withRange(fromInclude, toExclude endpoint, step int) iterator {
 	return &rangeIt{
@@ -56,7 +129,11 @@ type base struct {
 }
 
 func (b *base) GetSizeIfKnown() int64 {
-	return int64(b.size)
+	return int64(b.size - b.current)
+}
+
+func (b *base) IsInfinite() bool {
+	return false
 }
 
 func (b *base) HasNext() bool {
@@ -186,6 +263,46 @@ func (it *mapIt) Next() types.T {
 
 // end region mapIt
 
+// region syncMapIt
+// syncMapIt sync.Map 迭代器, 第一次调用 HasNext 时通过 Range 获取所有元素的快照
+type syncMapIt struct {
+	m       *sync.Map
+	entries []types.Pair
+	loaded  bool
+	current int
+}
+
+func (it *syncMapIt) GetSizeIfKnown() int64 {
+	return unkonwnSize
+}
+
+func (it *syncMapIt) IsInfinite() bool {
+	return false
+}
+
+func (it *syncMapIt) HasNext() bool {
+	if !it.loaded {
+		it.loaded = true
+		it.m.Range(func(key, value interface{}) bool {
+			it.entries = append(it.entries, types.Pair{
+				First:  key,
+				Second: value,
+			})
+			return true
+		})
+	}
+	return it.current < len(it.entries)
+}
+
+func (it *syncMapIt) Next() types.T {
+	it.HasNext()
+	e := it.entries[it.current]
+	it.current++
+	return e
+}
+
+// end region syncMapIt
+
 // region seedIt
 // 种子迭代器, 通过传入的UnaryOperator生成下一个元素
 type seedIt struct {
@@ -198,6 +315,10 @@ func (s *seedIt) GetSizeIfKnown() int64 {
 	return unkonwnSize
 }
 
+func (s *seedIt) IsInfinite() bool {
+	return true
+}
+
 func (s *seedIt) HasNext() bool {
 	return true
 }
@@ -223,6 +344,10 @@ func (s *supplierIt) GetSizeIfKnown() int64 {
 	return unkonwnSize
 }
 
+func (s *supplierIt) IsInfinite() bool {
+	return true
+}
+
 func (s *supplierIt) HasNext() bool {
 	return true
 }
@@ -246,6 +371,11 @@ func (r *rangeIt) GetSizeIfKnown() int64 {
 	return unkonwnSize
 }
 
+// step 为 0 时, 如果 from < to 则永远不会结束
+func (r *rangeIt) IsInfinite() bool {
+	return r.step == 0 && r.from.CompareTo(r.to) < 0
+}
+
 func (r *rangeIt) HasNext() bool {
 	if r.step >= 0 {
 		return r.next.CompareTo(r.to) < 0
@@ -261,6 +391,456 @@ func (r *rangeIt) Next() types.T {
 
 // end region rangeIt
 
+// region countIt
+// countIt 最多返回 remaining 个元素, 用于给无限迭代器加上长度
+type countIt struct {
+	source    iterator
+	remaining int64
+}
+
+func (c *countIt) GetSizeIfKnown() int64 {
+	if size := c.source.GetSizeIfKnown(); size >= 0 && size < c.remaining {
+		return size
+	}
+	return c.remaining
+}
+
+func (c *countIt) IsInfinite() bool {
+	return false
+}
+
+func (c *countIt) HasNext() bool {
+	return c.remaining > 0 && c.source.HasNext()
+}
+
+func (c *countIt) Next() types.T {
+	c.remaining--
+	return c.source.Next()
+}
+
+// end region countIt
+
+// region cycleIt
+// cycleIt 循环返回 elements 中的元素, times 是剩余的循环次数(包括当前这一次), 负数表示无限循环
+type cycleIt struct {
+	elements []types.T
+	times    int
+	index    int // 当前循环中下一个元素的下标
+}
+
+func (c *cycleIt) GetSizeIfKnown() int64 {
+	if c.times < 0 {
+		return unkonwnSize
+	}
+	if c.times == 0 {
+		return 0
+	}
+	n := int64(len(c.elements))
+	current := n - int64(c.index)
+	rest := int64(c.times - 1)
+	if rest > (math.MaxInt64-current)/n { // 溢出时取 math.MaxInt64
+		return math.MaxInt64
+	}
+	return current + rest*n
+}
+
+func (c *cycleIt) IsInfinite() bool {
+	return c.times < 0
+}
+
+func (c *cycleIt) HasNext() bool {
+	return c.times != 0
+}
+
+func (c *cycleIt) Next() types.T {
+	t := c.elements[c.index]
+	c.index++
+	if c.index == len(c.elements) {
+		c.index = 0
+		if c.times > 0 {
+			c.times--
+		}
+	}
+	return t
+}
+
+// end region cycleIt
+
+// region linesIt
+// linesIt 按行读取 io.Reader 的迭代器, 读取失败时 panic
+type linesIt struct {
+	scanner *bufio.Scanner
+	scanned bool // 是否已经预读了下一行
+	hasNext bool
+}
+
+func (l *linesIt) GetSizeIfKnown() int64 {
+	return unkonwnSize
+}
+
+func (l *linesIt) IsInfinite() bool {
+	return false
+}
+
+func (l *linesIt) HasNext() bool {
+	if !l.scanned {
+		l.hasNext = l.scanner.Scan()
+		l.scanned = true
+		if !l.hasNext {
+			if err := l.scanner.Err(); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return l.hasNext
+}
+
+func (l *linesIt) Next() types.T {
+	l.HasNext()
+	l.scanned = false
+	return l.scanner.Text()
+}
+
+// end region linesIt
+
+// region pullIt
+// pullIt 把推送方式的流转为拉取方式的迭代器: 每次 HasNext 向流中推送源的元素, 直到终止节点收到元素或者流结束.
+// 终止节点收到的元素先缓存起来, 流结束时调用 End(可能会继续发送元素, 如 Sorted) 并关闭流
+type pullIt struct {
+	s       *stream
+	stage   stage
+	buffer  []types.T
+	started bool
+	ended   bool
+	closed  bool
+}
+
+// 开始拉取之前, 如果所有操作都不改变元素个数, 返回数据源的元素个数
+func (p *pullIt) GetSizeIfKnown() int64 {
+	if p.started {
+		return unkonwnSize
+	}
+	return p.s.passThroughSize()
+}
+
+func (p *pullIt) IsInfinite() bool {
+	return p.s.infinite(false)
+}
+
+func (p *pullIt) HasNext() bool {
+	if !p.started {
+		p.start()
+	}
+	for len(p.buffer) == 0 && !p.ended {
+		source := p.s.source
+		if !p.stage.CanFinish() && source.HasNext() {
+			p.stage.Accept(source.Next())
+			continue
+		}
+		p.ended = true
+		p.stage.End()
+		p.closeStream()
+	}
+	return len(p.buffer) > 0
+}
+
+func (p *pullIt) Next() types.T {
+	p.HasNext()
+	t := p.buffer[0]
+	p.buffer[0] = nil
+	p.buffer = p.buffer[1:]
+	return t
+}
+
+func (p *pullIt) start() {
+	p.started = true
+	// 按需拉取, 相当于可以提前结束的终止操作
+	if p.s.infinite(true) {
+		panic(ErrInfiniteStream)
+	}
+	p.stage = p.s.wrapStage(newTerminalStage(func(t types.T) {
+		p.buffer = append(p.buffer, t)
+	}))
+	p.stage.Begin(p.s.source.GetSizeIfKnown())
+}
+
+// close 关闭流, 没有拉取完的元素被丢弃. 可以多次调用
+func (p *pullIt) close() {
+	p.ended = true
+	p.buffer = nil
+	p.closeStream()
+}
+
+// 调用流的关闭回调, 只会调用一次
+func (p *pullIt) closeStream() {
+	if !p.closed {
+		p.closed = true
+		p.s.close()
+	}
+}
+
+// end region pullIt
+
+// region generatorIt
+// generatorIt 在第一次 HasNext 时启动一个 goroutine 运行 produce, emit 的元素通过无缓冲的 channel 传给迭代器.
+// stop 关闭 done 后, 阻塞在 emit 中的 goroutine 调用 runtime.Goexit 退出. produce 的 panic 在迭代器中重新 panic
+type generatorIt struct {
+	produce   func(emit types.Consumer)
+	ch        chan types.T
+	done      chan struct{}
+	recovered interface{} // produce 的 panic, channel 关闭后才可以读取
+	started   bool
+	stopped   bool
+	fetched   bool // 是否已经预读了下一个元素
+	hasNext   bool
+	next      types.T
+}
+
+func (g *generatorIt) GetSizeIfKnown() int64 {
+	return unkonwnSize
+}
+
+func (g *generatorIt) IsInfinite() bool {
+	return false
+}
+
+func (g *generatorIt) HasNext() bool {
+	if !g.started {
+		g.start()
+	}
+	if !g.fetched {
+		g.next, g.hasNext = <-g.ch
+		g.fetched = true
+		if !g.hasNext && g.recovered != nil {
+			panic(g.recovered)
+		}
+	}
+	return g.hasNext
+}
+
+func (g *generatorIt) Next() types.T {
+	g.HasNext()
+	g.fetched = false
+	t := g.next
+	g.next = nil
+	return t
+}
+
+func (g *generatorIt) start() {
+	g.started = true
+	go func() {
+		defer close(g.ch)
+		defer func() {
+			g.recovered = recover() // runtime.Goexit 时是 nil
+		}()
+		g.produce(func(t types.T) {
+			select {
+			case g.ch <- t:
+			case <-g.done:
+				runtime.Goexit()
+			}
+		})
+	}()
+}
+
+// stop 通知 goroutine 在下一次 emit 时退出, 可以多次调用
+func (g *generatorIt) stop() {
+	if !g.stopped {
+		g.stopped = true
+		close(g.done)
+	}
+}
+
+// end region generatorIt
+
+// region concatIt
+// concatIt 依次遍历每个迭代器, 当前的迭代器结束后才会访问下一个
+type concatIt struct {
+	its   []iterator
+	index int // 当前迭代器的下标
+}
+
+func (c *concatIt) GetSizeIfKnown() int64 {
+	return sumSizes(0, c.its[c.index:])
+}
+
+// sumSizes 返回 base 加上所有迭代器的元素个数, 溢出时取 math.MaxInt64, 有未知的个数时返回 unkonwnSize
+func sumSizes(base int64, its []iterator) int64 {
+	size := base
+	for _, it := range its {
+		n := it.GetSizeIfKnown()
+		if n < 0 {
+			return unkonwnSize
+		}
+		if n > math.MaxInt64-size { // 溢出时取 math.MaxInt64
+			size = math.MaxInt64
+		} else {
+			size += n
+		}
+	}
+	return size
+}
+
+func (c *concatIt) IsInfinite() bool {
+	for _, it := range c.its[c.index:] {
+		if it.IsInfinite() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *concatIt) HasNext() bool {
+	for c.index < len(c.its) && !c.its[c.index].HasNext() {
+		c.its[c.index] = nil
+		c.index++
+	}
+	return c.index < len(c.its)
+}
+
+func (c *concatIt) Next() types.T {
+	c.HasNext()
+	return c.its[c.index].Next()
+}
+
+// end region concatIt
+
+// region mergeIt
+// mergeIt 用最小堆归并多个已排序的迭代器, 每个迭代器最多预先取出一个元素
+// see heap.Interface
+type mergeIt struct {
+	its     []iterator
+	keyFn   types.Function
+	keyCmp  types.Comparator
+	heads   []mergeHead // 堆, 每个未结束的迭代器的当前元素
+	started bool
+}
+
+type mergeHead struct {
+	index   int // 所属迭代器的下标, key 相同时下标小的在前
+	key     types.R
+	element types.T
+}
+
+// start 第一次拉取时才从每个迭代器取出第一个元素
+func (m *mergeIt) start() {
+	if m.started {
+		return
+	}
+	m.started = true
+	m.heads = make([]mergeHead, 0, len(m.its))
+	for i, it := range m.its {
+		if it.HasNext() {
+			e := it.Next()
+			m.heads = append(m.heads, mergeHead{index: i, key: m.keyFn(e), element: e})
+		}
+	}
+	heap.Init(m)
+}
+
+func (m *mergeIt) GetSizeIfKnown() int64 {
+	return sumSizes(int64(len(m.heads)), m.its) // 已取出的当前元素也要计入
+}
+
+func (m *mergeIt) IsInfinite() bool {
+	for _, it := range m.its {
+		if it.IsInfinite() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mergeIt) HasNext() bool {
+	m.start()
+	return len(m.heads) > 0
+}
+
+func (m *mergeIt) Next() types.T {
+	m.start()
+	top := &m.heads[0]
+	e := top.element
+	if it := m.its[top.index]; it.HasNext() {
+		top.element = it.Next()
+		top.key = m.keyFn(top.element)
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return e
+}
+
+// Len is the number of elements in the collection.
+func (m *mergeIt) Len() int {
+	return len(m.heads)
+}
+
+// Less reports whether the element with
+// index i should sort before the element with index j.
+func (m *mergeIt) Less(i, j int) bool {
+	if c := m.keyCmp(m.heads[i].key, m.heads[j].key); c != 0 {
+		return c < 0
+	}
+	return m.heads[i].index < m.heads[j].index
+}
+
+// Swap swaps the elements with indexes i and j.
+func (m *mergeIt) Swap(i, j int) {
+	m.heads[i], m.heads[j] = m.heads[j], m.heads[i]
+}
+
+// Push add x as element Len()
+func (m *mergeIt) Push(x interface{}) {
+	m.heads = append(m.heads, x.(mergeHead))
+}
+
+// Pop remove and return element Len() - 1.
+func (m *mergeIt) Pop() interface{} {
+	n := len(m.heads)
+	x := m.heads[n-1]
+	m.heads = m.heads[:n-1]
+	return x
+}
+
+// end region mergeIt
+
+// region splitIt
+// splitIt 是 SplitAt 返回的两个流共用的迭代器, remaining 是 head 中剩余的元素个数
+type splitIt struct {
+	pull      *pullIt
+	remaining *int64
+	head      bool
+}
+
+func (s *splitIt) GetSizeIfKnown() int64 {
+	return unkonwnSize
+}
+
+func (s *splitIt) IsInfinite() bool {
+	return !s.head && s.pull.IsInfinite()
+}
+
+func (s *splitIt) HasNext() bool {
+	if s.head {
+		return *s.remaining > 0 && s.pull.HasNext()
+	}
+	for *s.remaining > 0 && s.pull.HasNext() { // 先跳过 head 中没有消费的元素
+		s.pull.Next()
+		*s.remaining--
+	}
+	return s.pull.HasNext()
+}
+
+func (s *splitIt) Next() types.T {
+	s.HasNext()
+	if s.head {
+		*s.remaining--
+	}
+	return s.pull.Next()
+}
+
+// end region splitIt
+
 // region Sortable
 // Sortable use types.Comparator to sort []types.T 可以使用指定的 cmp 比较器对 list 进行排序
 // see sort.Interface
@@ -285,6 +865,66 @@ func (a *Sortable) Swap(i, j int) {
 
 //end region Sortable
 
+// region boundedHeap
+// boundedHeap 最多保留比较器意义下最小的 limit 个元素, 堆顶是其中最大的元素
+// see heap.Interface
+type boundedHeap struct {
+	Sortable // Cmp 是反转后的比较器, 所以堆顶是最大的元素
+	cmp   types.Comparator
+	limit int64
+}
+
+func newBoundedHeap(cmp types.Comparator, limit int64, sizeMayNegative int64) *boundedHeap {
+	capacity := limit
+	if sizeMayNegative >= 0 && sizeMayNegative < capacity {
+		capacity = sizeMayNegative
+	}
+	return &boundedHeap{
+		Sortable: Sortable{
+			List: make([]types.T, 0, capacityOf(capacity)),
+			Cmp:  types.ReverseOrder(cmp),
+		},
+		cmp:   cmp,
+		limit: limit,
+	}
+}
+
+// Push add x as element Len()
+func (h *boundedHeap) Push(x interface{}) {
+	h.List = append(h.List, x)
+}
+
+// Pop remove and return element Len() - 1.
+func (h *boundedHeap) Pop() interface{} {
+	n := len(h.List)
+	x := h.List[n-1]
+	h.List = h.List[:n-1]
+	return x
+}
+
+// Offer 添加一个元素, 已满时如果 t 比堆顶小则替换堆顶
+func (h *boundedHeap) Offer(t types.T) {
+	if int64(len(h.List)) < h.limit {
+		heap.Push(h, t)
+		return
+	}
+	if len(h.List) > 0 && h.cmp(t, h.List[0]) < 0 {
+		h.List[0] = t
+		heap.Fix(h, 0)
+	}
+}
+
+// Sorted 返回按比较器升序排列的所有元素
+func (h *boundedHeap) Sorted() []types.T {
+	sort.Sort(&Sortable{
+		List: h.List,
+		Cmp:  h.cmp,
+	})
+	return h.List
+}
+
+// end region boundedHeap
+
 // region endpoint
 // endpoint used in rangeIt.
 type endpoint interface {
@@ -311,6 +951,16 @@ func (m epInt64) CompareTo(other endpoint) int {
 func (m epInt64) Add(step int) endpoint {
 	return m + epInt64(step)
 }
+
+type epRune rune
+
+func (m epRune) CompareTo(other endpoint) int {
+	return int(m - other.(epRune))
+}
+
+func (m epRune) Add(step int) endpoint {
+	return m + epRune(step)
+}
 // end region endpoint
 
 
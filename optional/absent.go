@@ -47,5 +47,25 @@ func (a absent) OrPanicGet(getPanicArg types.Supplier) types.T {
 	panic(getPanicArg())
 }
 
+func (a absent) String() string {
+	return "Optional.empty"
+}
+
+func (a absent) Equals(other Optional, eq func(a, b types.T) bool) bool {
+	return !other.IsPresent()
+}
+
+func (a absent) ToPointer() *types.T {
+	return nil
+}
+
+func (a absent) FlatMapOr(flatMapper func(t types.T) Optional, fallback Optional) Optional {
+	return fallback
+}
+
+func (a absent) MapSafe(types.Function) Optional {
+	return a
+}
+
 
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/rhzx3519/stream/optional"
 	"github.com/rhzx3519/stream/types"
+	"strconv"
 )
 
 func ExampleEmpty() {
@@ -110,3 +111,77 @@ func ExampleOfNullable() {
 	// nil(*string) IsPresent: false
 	// IsPresent: true
 }
+func ExampleOptional_String() {
+	fmt.Println(optional.Of(1))
+	fmt.Printf("%v\n", optional.OfNullable("a"))
+	fmt.Printf("%s\n", optional.OfNullable(nil))
+	fmt.Println(optional.Of([]int{}))
+	// Output:
+	// Optional[1]
+	// Optional[a]
+	// Optional.empty
+	// Optional[[]]
+}
+func ExampleOptional_Equals() {
+	eq := func(a, b types.T) bool {
+		return a == b
+	}
+	fmt.Println(optional.Empty().Equals(optional.Empty(), eq))
+	fmt.Println(optional.Of(1).Equals(optional.Empty(), eq))
+	fmt.Println(optional.Empty().Equals(optional.Of(1), eq))
+	fmt.Println(optional.Of(1).Equals(optional.Of(1), eq))
+	fmt.Println(optional.Of(1).Equals(optional.Of(2), eq))
+	// Output:
+	// true
+	// false
+	// false
+	// true
+	// false
+}
+func ExampleOptional_ToPointer() {
+	fmt.Println(*optional.Of("a").ToPointer())
+	fmt.Println(optional.Empty().ToPointer() == nil)
+	// Output:
+	// a
+	// true
+}
+func ExampleOptional_FlatMapOr() {
+	users := map[string]string{"u1": "alice"}
+	lookup := func(t types.T) optional.Optional {
+		if name, ok := users[t.(string)]; ok {
+			return optional.Of(name)
+		}
+		return optional.Empty()
+	}
+	guest := optional.Of("guest")
+	fmt.Println(optional.Of("u1").FlatMapOr(lookup, guest))
+	fmt.Println(optional.Of("u2").FlatMapOr(lookup, guest))
+	fmt.Println(optional.Empty().FlatMapOr(lookup, guest))
+	// Output:
+	// Optional[alice]
+	// Optional[guest]
+	// Optional[guest]
+}
+func ExampleOptional_MapSafe() {
+	config := map[string]interface{}{"port": "8080", "debug": true}
+	port := func(key string) optional.Optional {
+		return optional.OfNullable(config[key]).
+			MapSafe(func(t types.T) types.R {
+				return t.(string) // 不是 string 时 panic
+			}).
+			MapSafe(func(t types.T) types.R {
+				port, err := strconv.Atoi(t.(string))
+				if err != nil {
+					panic(err)
+				}
+				return port
+			})
+	}
+	fmt.Println(port("port"))
+	fmt.Println(port("debug"))
+	fmt.Println(port("missing"))
+	// Output:
+	// Optional[8080]
+	// Optional.empty
+	// Optional.empty
+}
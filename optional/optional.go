@@ -19,6 +19,18 @@ type Optional interface {
 	OrElseGet(types.Supplier) types.T              // OrElseGet: if absent, call Supplier and return it's result. if value present return it
 	OrPanic(panicArg interface{}) types.T          // OrPanic:if absent, panic with `panicArg`, if value present, return it
 	OrPanicGet(getPanicArg types.Supplier) types.T // OrPanicGet: if absent, panic with the given supplier's result. if value present, return it
+	String() string                                // String returns "Optional[value]" if value present, or "Optional.empty"
+
+	// Equals: two empty Optionals are equal, present and empty are not equal,
+	// if both present, their values are compared by `eq`
+	Equals(other Optional, eq func(a, b types.T) bool) bool
+	// ToPointer returns a pointer to the value if present, or nil if absent
+	ToPointer() *types.T
+	// FlatMapOr: if has value, apply the given flatten-Function and return the result unless it's empty,
+	// return `fallback` if absent or the result is empty
+	FlatMapOr(flatMapper func(t types.T) Optional, fallback Optional) Optional
+	// MapSafe: like Map, but return Empty if the mapper panics, so a chain of MapSafe degrades to Empty on any failure
+	MapSafe(mapper types.Function) Optional
 }
 
 var (
@@ -1,6 +1,9 @@
 package optional
 
-import "github.com/rhzx3519/stream/types"
+import (
+	"fmt"
+	"github.com/rhzx3519/stream/types"
+)
 
 /**
 	present implements Optional interface which is not nil
@@ -52,5 +55,34 @@ func (p *present) OrPanicGet(getPanicArg types.Supplier) types.T {
 	return p.value
 }
 
+func (p *present) String() string {
+	return fmt.Sprintf("Optional[%v]", p.value)
+}
+
+func (p *present) Equals(other Optional, eq func(a, b types.T) bool) bool {
+	return other.IsPresent() && eq(p.value, other.Get())
+}
+
+func (p *present) ToPointer() *types.T {
+	return &p.value
+}
+
+func (p *present) FlatMapOr(flatMapper func(t types.T) Optional, fallback Optional) Optional {
+	if result := flatMapper(p.value); result.IsPresent() {
+		return result
+	}
+	return fallback
+}
+
+func (p *present) MapSafe(mapper types.Function) (result Optional) {
+	defer func() {
+		if result == nil { // mapper panic 了
+			recover()
+			result = emtpy
+		}
+	}()
+	return p.Map(mapper)
+}
+
 
 
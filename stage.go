@@ -32,6 +32,9 @@ func (b *baseStage) Accept(t types.T) {
 }
 
 func (b *baseStage) CanFinish() bool {
+	if b.canFinish == nil { // 终止操作默认不会提前结束
+		return false
+	}
 	return b.canFinish()
 }
 
@@ -108,7 +111,7 @@ func defaultTerminalStage(action types.Consumer) *terminalStage {
 		&baseStage{
 			begin: func(int64) {},
 			action: action,
-			canFinish: func() bool { return false },
+			canFinish: nil, // nil 表示不会提前结束, 无限流上的这类终止操作会 panic
 			end: func() {},
 		},
 	}
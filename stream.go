@@ -1,8 +1,10 @@
 package stream
 
 import (
+	"context"
 	"github.com/rhzx3519/stream/optional"
 	"github.com/rhzx3519/stream/types"
+	"io"
 	"reflect"
 )
 
@@ -10,42 +12,130 @@ import (
 // It has stateless operates(Filter, Map, FlatMap, Peek),
 // stateful operates(Distinct, Sorted, Limit, Skip),
 // and the left methods are terminal operates.
+//
+// elements are pushed through the operates one by one, so side effects(e.g. Peek) of different operates interleave
+// per element, except across a buffering operate(Sorted, SortedLimit, GroupByToStream...): it holds all elements
+// until the upstream ends, then flushes them. so a Peek before Sorted fires in source order while elements arrive,
+// and a Peek after Sorted fires in sorted order after all the upstream side effects.
+//
+// 元素逐个经过每个操作, 但是缓存所有元素的操作(如 Sorted)之前的副作用都发生在它之后的副作用之前
 type Stream interface {
 	// stateless operate 无状态操作
 
 	Filter(types.Predicate) Stream		// 过滤
+	FilterIndexed(func(index int64, t types.T) bool) Stream // 带下标的过滤
+	FilterCounting(test types.Predicate, rejected *int64) Stream // 过滤, 并统计丢弃的元素个数
 	Map(types.Function) Stream						// 转换
+	MapInPlace(types.UnaryOperator) Stream			// 原地转换, 结果和元素类型相同
+	MapWith(u types.U, apply types.BiFunction) Stream // 使用固定的第二个参数转换
+	MapAll(funcs ...types.Function) Stream			// 依次使用每个函数转换
+	Cast(typ reflect.Type) Stream					// 断言元素类型
+	Deref() Stream									// 指针转为指向的值
+	DerefOr(defaultVal types.T) Stream				// 指针转为指向的值, nil 指针转为 defaultVal
+	MapOptional(func(t types.T) optional.Optional) Stream // 转换并丢弃空的结果
+	TryMap(types.Function) Stream					// 转换为 optional.Optional, panic 时为空
+	MapConcurrent(parallelism int, apply types.Function) Stream // 并发转换, 保持顺序
 	FlatMap(func(types.T) Stream) Stream			// 打平
+	FlatMapSized(sizeHint int64, flatten func(types.T) Stream) Stream // 打平, 并给出预估的元素个数
+	Flatten() Stream								// 打平元素类型为 []types.T 或 Stream 的流
 	Peek(types.Consumer) Stream						// peek 每个元素
+	Collecting(dst *[]types.T) Stream				// 把经过的元素追加到 dst
+	PeekLog(logf func(format string, args ...interface{}), prefix string) Stream // 用 logf 记录每个元素
+	WithProgress(every int64, report func(done int64, total int64)) Stream // 定期报告进度
+	PeekProgress(consumer func(index, total, done int64, t types.T)) Stream // peek 每个元素及其进度
+	// PeekLifecycle 在 begin, 每个元素, end 时分别调用对应的回调
+	PeekLifecycle(onBegin func(size int64), onEach types.Consumer, onEnd func()) Stream
+	OnStart(fn func(sizeHint int64)) Stream			// begin 时回调
+	OnComplete(fn func()) Stream					// end 时回调
+	MapToPair(keyFn, valueFn types.Function) Stream // 转换为 Pair
+	Enumerate() Stream								// 转换为 Pair{下标, 元素}
+	MapKeys(types.Function) Stream					// 转换 Pair 的 First
+	MapValues(types.Function) Stream				// 转换 Pair 的 Second
+	MapPair(keyMapper, valueMapper types.Function) Stream // 同时转换 Pair 的 First 和 Second
+	Keys() Stream									// 取出 Pair 的 First
+	Values() Stream									// 取出 Pair 的 Second
+	OnClose(func()) Stream							// 注册关闭回调
+	// Recover 捕获下游处理元素时的 panic, 使用 handler 的返回值代替该元素
+	Recover(handler func(recovered interface{}, element types.T) types.T) Stream
 
 	// stateful operate 有状态操作
 
 	Distinct(types.IntFunction) Stream 	// 去重
+	Duplicates(distincter types.IntFunction, everyRepeat bool) Stream // 只保留重复出现的元素
+	DistinctLast(types.IntFunction) Stream 	// 去重, 保留最后出现的元素
+	DistinctByHashEquals(hash types.IntFunction, equals func(a, b types.T) bool) Stream // 按 hash 和 equals 去重
+	DistinctBounded(distincter types.IntFunction, maxKeys int, policy DistinctPolicy) Stream // 最多记住 maxKeys 个元素的去重
 	Sorted(types.Comparator) Stream		// 排序
+	SortedInto(cmp types.Comparator, scratch []types.T) Stream // 排序, 使用 scratch 缓存元素
+	SortedDesc(types.Comparator) Stream	// 降序排序
+	SortedNaturalDesc() Stream			// 按自然顺序降序排序
+	SortedLimit(cmp types.Comparator, k int64) Stream // 排序后取前 k 个元素
+	SortedPage(cmp types.Comparator, offset, limit int64) Stream // 排序后跳过 offset 个元素, 再取 limit 个元素
 	Limit(int64) Stream								// 限制个数
+	LimitWeight(maxWeight int64, weightFn func(t types.T) int64) Stream // 限制重量之和
+	TakeUntil(types.Predicate) Stream				// 取元素直到(包括)第一个满足条件的元素
 	Skip(int64) Stream								// 跳过个数
+	SkipN(n int64) Stream							// 同 Skip, 按个数跳过
+	SkipWhile(types.Predicate) Stream				// 跳过开头满足条件的元素
+	Buffer(size int) Stream							// 缓存 size 个元素后一起发送给下游
+	WindowBy(keyFn types.Function) Stream			// 连续的 key 相同的元素组成一个窗口
+	ChunkOf(typ reflect.Type, size int) Stream		// 分块, 每块是 []typ 类型的切片
+	// WindowReduce 滑动窗口, 发送每个窗口归约后的结果
+	WindowReduce(size, step int, partial bool, reduce func(window []types.T) types.R) Stream
+	GroupByToStream(classifier types.Function) Stream // 分组, 每组是一个 Pair{key, []types.T}
+	// SplitAt 分为前 n 个元素和剩下的元素两个流, 需要先消费 head 再消费 tail
+	SplitAt(n int64) (head Stream, tail Stream)
+
+	// Explain 描述流中的所有操作, 用于调试
+	Explain() string
 
 	// terminal operate 终止操作
 
 	// 遍历
 	ForEach(types.Consumer)
+	// 按顺序遍历
+	ForEachOrdered(types.Consumer)
+	// 遍历直到 consumer 返回 false
+	ForEachUntil(consumer func(t types.T) bool)
+	// 遍历并返回元素个数
+	ForEachCount(types.Consumer) int64
+	// 分批遍历, 每批最多 size 个元素
+	ForEachBatch(size int, sink func(batch []types.T))
 	// return []T 转为切片
 	ToSlice() []types.T
+	// ToSliceInto 追加到 dst 并返回, 可复用 dst 的底层数组
+	ToSliceInto(dst []types.T) []types.T
+	// SetSlice 去重后转为切片, 保持第一次出现的顺序
+	SetSlice(distincter types.IntFunction) []types.T
 	// return []X which X is the type of some
 	ToElementSlice(some types.T) types.R
 	// return []X which X is same as the `typ` representation
 	ToSliceOf(typ reflect.Type) types.R
-	// 测试是否所有元素满足条件
+	// return map[K]V which K, V are same as the `keyType`, `valueType` representation
+	ToMapOf(keyType, valueType reflect.Type, keyFn, valueFn types.Function) types.R
+	// 测试是否所有元素满足条件, 空的流返回 true
 	AllMatch(types.Predicate) bool
-	// 测试是否没有元素满足条件
+	// 测试是否没有元素满足条件, 空的流返回 true
 	NoneMatch(types.Predicate) bool
-	// 测试有任意元素满足条件
+	// 测试有任意元素满足条件, 空的流返回 false
 	AnyMatch(types.Predicate) bool
+	// 测试有任意元素和它的下标满足条件
+	AnyMatchIndexed(test func(index int64, t types.T) bool) bool
 	// Reduce return optional.Empty if no element.
 	// calculate result by (T, T) -> T from first element, panic if reduction is nil
 	Reduce(accumulator types.BinaryOperator) optional.Optional
+	// ReduceOk like Reduce, the bool result is false if no element
+	ReduceOk(accumulator types.BinaryOperator) (types.T, bool)
+	// ReduceRight 从最后一个元素开始向前归约, 需要缓存所有元素
+	ReduceRight(accumulator types.BinaryOperator) optional.Optional
+	// ReduceCounting 归约并返回元素个数
+	ReduceCounting(accumulator types.BinaryOperator) (result optional.Optional, count int64)
 	// type of initValue is same as element.  (T, T) -> T
 	ReduceFrom(initValue types.T, accumulator types.BinaryOperator) types.T
+	// (T, T) -> T, combiner is used to merge partial results when executed in parallel
+	ReduceIdentity(identity types.T, accumulator types.BinaryOperator, combiner types.BinaryOperator) types.T
+	// ParallelReduce 分段在多个 goroutine 中归约, 再用 combiner 合并
+	ParallelReduce(parallelism int, identity types.T, accumulator, combiner types.BinaryOperator) types.T
 	// type of initValue is different from element. (R, T) -> R
 	ReduceWith(initValue types.R, accumulator func(acc types.R, e types.T) types.R) types.R
 	// ReduceBy use `buildInitValue` to build the initValue,
@@ -53,6 +143,38 @@ type Stream interface {
 	// Then use `accumulator` to add each element to previous result
 	ReduceBy(buildInitValue func(sizeMayNegative int64) types.R, accumulator func(acc types.R, e types.T) types.R) types.R
 	FindFirst() optional.Optional
+	// FindAny 返回任意一个元素, 不保证是第一个
+	FindAny() optional.Optional
+	// Last 返回最后一个元素, 需要遍历所有元素
+	Last() optional.Optional
+	// MinMax 一次遍历返回最小和最大的元素
+	MinMax(cmp types.Comparator) (min optional.Optional, max optional.Optional)
+	// TopN 返回最大的 n 个元素, 按从大到小排列
+	TopN(n int, cmp types.Comparator) []types.T
+	// Head 返回前 n 个元素
+	Head(n int) []types.T
 	// 返回元素个数
 	Count() int64
+	// JoiningMap 把每个元素转为字符串后用 sep 连接
+	JoiningMap(toString types.Function, sep string) string
+	// JoiningLimit 最多连接 maxElems 个元素, 还有更多元素时追加 ellipsis
+	JoiningLimit(toString types.Function, sep string, maxElems int, ellipsis string) string
+	// ToJSON 把所有元素编码为 JSON 数组写入 io.Writer
+	ToJSON(w io.Writer) error
+	// ToWriter 把每个元素格式化后写入 io.Writer, 遇到错误时提前结束
+	ToWriter(w io.Writer, format func(t types.T) []byte) (int64, error)
+	// Collect 使用 Collector 归约所有元素
+	Collect(collector Collector) types.R
+	// Teeing feeds each element to both collectors in one pass, then merges the two results
+	Teeing(c1, c2 Collector, merge func(r1, r2 types.R) types.R) types.R
+	// PairsToMap 把 Pair 元素转为 map
+	PairsToMap() map[types.R]types.R
+	// PartitionErrors 把 Pair{值, error} 元素分为值和错误
+	PartitionErrors() (values []types.T, errs []error)
+	// ToMapReducing 按 key 分组, 每组的值用 merge 归约
+	ToMapReducing(keyFn types.Function, valueFn types.Function, merge types.BinaryOperator) map[types.R]types.R
+	// GroupByThen 分组, 每组元素用 downstream 归约
+	GroupByThen(classifier types.Function, downstream Collector) map[types.R]types.R
+	// Async 在新的 goroutine 中执行流, 结果和错误通过 channel 返回, 取消 ctx 可中止执行
+	Async(ctx context.Context, buffer int) (<-chan types.T, <-chan error)
 }
\ No newline at end of file
@@ -0,0 +1,34 @@
+package streamtest_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rhzx3519/stream/streamtest"
+	"github.com/rhzx3519/stream/types"
+)
+
+func ExampleCheckAssociative() {
+	samples := []types.T{3, 1, 4, 1, 5, 9, 2, 6}
+	add := func(acc types.T, t types.T) types.T {
+		return acc.(int) + t.(int)
+	}
+	fmt.Println(streamtest.CheckAssociative(0, add, add, samples))
+
+	// combiner 和 accumulator 的顺序不一致
+	concat := func(acc types.T, t types.T) types.T {
+		return acc.(string) + t.(string)
+	}
+	reversed := func(acc types.T, t types.T) types.T {
+		return t.(string) + acc.(string)
+	}
+	err := streamtest.CheckAssociative("", concat, reversed, []types.T{"a", "b", "c"})
+	fmt.Println(errors.Is(err, streamtest.ErrNotAssociative), err)
+
+	// 1 不是加法的单位元
+	err = streamtest.CheckAssociative(1, add, add, samples)
+	fmt.Println(err)
+	// Output:
+	// <nil>
+	// true associativity law violated: chunks [[a b] [c]] reduced to cab, but sequential result is abc
+	// identity law violated: combiner(1, 4) = 5, want 4
+}
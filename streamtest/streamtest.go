@@ -0,0 +1,83 @@
+// Package streamtest provides helpers to test functions used with stream.
+package streamtest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rhzx3519/stream/types"
+	"math/rand"
+	"reflect"
+)
+
+var (
+	// ErrNotIdentity a error returned by CheckAssociative when combiner(identity, u) is not u
+	ErrNotIdentity = errors.New("identity law violated")
+	// ErrNotAssociative a error returned by CheckAssociative when a chunked reduction differs from the sequential one
+	ErrNotAssociative = errors.New("associativity law violated")
+)
+
+// 随机分段的次数
+const chunkTrials = 20
+
+// CheckAssociative verifies the laws Stream.ParallelReduce(and ReduceIdentity) requires over `samples`:
+//   - identity: combiner(identity, u) equals u, for u = accumulator(identity, t) of each sample and the sequential result
+//   - associativity: reducing random contiguous chunks from `identity` with `accumulator`, then combining the
+//     partial results in order with `combiner`, equals the sequential reduction of all samples
+//
+// results are compared by reflect.DeepEqual, and the chunks are chosen by a fixed seed, so it's deterministic.
+// it returns a error wrapping ErrNotIdentity or ErrNotAssociative which describes the first violation, or nil.
+// passing doesn't prove the laws, use samples that cover the edge cases of the reducer.
+func CheckAssociative(identity types.T, accumulator, combiner types.BinaryOperator, samples []types.T) error {
+	sequential := reduce(identity, accumulator, samples)
+	for _, t := range samples {
+		if err := checkIdentity(identity, combiner, accumulator(identity, t)); err != nil {
+			return err
+		}
+	}
+	if err := checkIdentity(identity, combiner, sequential); err != nil {
+		return err
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < chunkTrials && len(samples) > 1; i++ {
+		chunks := split(r, samples)
+		result := reduce(identity, accumulator, chunks[0])
+		for _, chunk := range chunks[1:] {
+			result = combiner(result, reduce(identity, accumulator, chunk))
+		}
+		if !reflect.DeepEqual(result, sequential) {
+			return fmt.Errorf("%w: chunks %v reduced to %v, but sequential result is %v",
+				ErrNotAssociative, chunks, result, sequential)
+		}
+	}
+	return nil
+}
+
+func checkIdentity(identity types.T, combiner types.BinaryOperator, u types.T) error {
+	if got := combiner(identity, u); !reflect.DeepEqual(got, u) {
+		return fmt.Errorf("%w: combiner(%v, %v) = %v, want %v", ErrNotIdentity, identity, u, got, u)
+	}
+	return nil
+}
+
+func reduce(identity types.T, accumulator types.BinaryOperator, elements []types.T) types.T {
+	result := identity
+	for _, t := range elements {
+		result = accumulator(result, t)
+	}
+	return result
+}
+
+// split 把 elements 随机分为至少两段连续的非空切片
+func split(r *rand.Rand, elements []types.T) [][]types.T {
+	var chunks [][]types.T
+	for len(elements) > 0 {
+		n := 1 + r.Intn(len(elements))
+		if len(chunks) == 0 && n == len(elements) {
+			n = len(elements) - 1
+		}
+		chunks = append(chunks, elements[:n])
+		elements = elements[n:]
+	}
+	return chunks
+}
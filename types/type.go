@@ -1,5 +1,11 @@
 package types
 
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
 type (
 	// T is a empty interface, that is `any` type.
 	// since Go is not support generics now(but will coming soon),
@@ -38,6 +44,26 @@ type (
 
 
 var (
+	// ErrNotComparable a error to panic when NaturalOrder meets a element which has no natural order
+	ErrNotComparable = errors.New("not comparable")
+
+	// NaturalOrder is a Comparator for ints, uints, floats, strings(and types based on them).
+	// both arguments must be the same kind, or it panics
+	NaturalOrder Comparator = func(left, right T) int {
+		l, r := reflect.ValueOf(left), reflect.ValueOf(right)
+		switch l.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return compare(l.Int() < r.Int(), l.Int() > r.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return compare(l.Uint() < r.Uint(), l.Uint() > r.Uint())
+		case reflect.Float32, reflect.Float64:
+			return compare(l.Float() < r.Float(), l.Float() > r.Float())
+		case reflect.String:
+			return compare(l.String() < r.String(), l.String() > r.String())
+		}
+		panic(ErrNotComparable)
+	}
+
 	// IntComparator is a Comparator for int
 	IntComparator Comparator = func(left, right T) int {
 		if left.(int) > right.(int) {
@@ -59,9 +85,41 @@ var (
 	}
 )
 
+// NewPair creates a Pair
+func NewPair(first T, second R) Pair {
+	return Pair{First: first, Second: second}
+}
+
+// Swap returns a new Pair which First and Second are exchanged
+func (p Pair) Swap() Pair {
+	return Pair{First: p.Second, Second: p.First}
+}
+
+func compare(less, greater bool) int {
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
+}
+
 // return a reversed comparator
 func ReverseOrder(cmp Comparator) Comparator {
 	return func(left, right T) int {
 		return cmp(right, left)
 	}
-}
\ No newline at end of file
+}
+
+// Memoize returns a Supplier which calls `get` only once and then returns the cached result.
+// it's safe for concurrent use. e.g. stream.Generate(types.Memoize(expensive)).Limit(n)
+func Memoize(get Supplier) Supplier {
+	var once sync.Once
+	var result R
+	return func() R {
+		once.Do(func() {
+			result = get()
+		})
+		return result
+	}
+}